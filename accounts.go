@@ -0,0 +1,150 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// accountRegisterer returns a registerer adding the account label to the
+// metrics of a named account, or reg itself for a single account.
+func accountRegisterer(reg prometheus.Registerer, account string) prometheus.Registerer {
+	if account == "" {
+		return reg
+	}
+	return prometheus.WrapRegistererWith(prometheus.Labels{"account": account}, reg)
+}
+
+// accountExporters are the exporters of the accounts, in the order of the
+// configuration.
+type accountExporters []*Exporter
+
+// get returns the exporter of an account, or of the first one if account is
+// empty.
+func (a accountExporters) get(account string) *Exporter {
+	if account == "" && len(a) > 0 {
+		return a[0]
+	}
+	for _, e := range a {
+		if e.account == account {
+			return e
+		}
+	}
+	return nil
+}
+
+// serveSnapshot serves the snapshot of the account parameter, the first
+// account by default.
+func (a accountExporters) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	e := a.get(r.URL.Query().Get("account"))
+	if e == nil {
+		http.Error(w, "Unknown account", http.StatusNotFound)
+		return
+	}
+	e.serveSnapshot(w, r)
+}
+
+// serveReady tells whether the API of any account was reached within window,
+// so that a failing account does not take the others out of service. The
+// reason of every account is in the body.
+func (a accountExporters) serveReady(window time.Duration) http.HandlerFunc {
+	if len(a) == 1 {
+		return a[0].serveReady(window)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reasons []string
+		anyReady := false
+		for _, e := range a {
+			ok, reason := e.ready(window)
+			anyReady = anyReady || ok
+			reasons = append(reasons, fmt.Sprintf("%s: %s", e.account, reason))
+		}
+		body := strings.Join(reasons, "\n")
+		if !anyReady {
+			http.Error(w, body, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(body + "\n"))
+	}
+}
+
+// accountApplier is anything following the configuration of an account:
+// exporters and the sources of pull zones of the other collectors.
+type accountApplier interface {
+	accountName() string
+	applyConfig(c *config)
+}
+
+// applyAccounts applies the configuration of their account to appliers.
+// Accounts can only be added or removed by a restart.
+func applyAccounts(appliers []accountApplier, c *config) error {
+	accounts := map[string]*accountConfig{}
+	for _, a := range c.accountConfigs() {
+		accounts[a.Name] = a
+	}
+	names := map[string]bool{}
+	for _, a := range appliers {
+		names[a.accountName()] = true
+		if _, ok := accounts[a.accountName()]; !ok {
+			return errors.New("accounts cannot change without a restart")
+		}
+	}
+	if len(names) != len(accounts) {
+		return errors.New("accounts cannot change without a restart")
+	}
+	for _, a := range appliers {
+		a.applyConfig(&accounts[a.accountName()].config)
+	}
+	return nil
+}
+
+// accountNamed returns the account of a name, or the first one if name is
+// empty.
+func accountNamed(accounts []*accountConfig, name string) (*accountConfig, error) {
+	for _, a := range accounts {
+		if name == "" || a.Name == name {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown account %q", name)
+}
+
+// accountHandlers serves the handler of the account parameter, the first
+// account by default.
+type accountHandlers struct {
+	accounts []string
+	handlers []http.Handler
+}
+
+func (a *accountHandlers) add(account string, h http.Handler) {
+	a.accounts = append(a.accounts, account)
+	a.handlers = append(a.handlers, h)
+}
+
+func (a *accountHandlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	account := r.URL.Query().Get("account")
+	for i, name := range a.accounts {
+		if account == "" || name == account {
+			a.handlers[i].ServeHTTP(w, r)
+			return
+		}
+	}
+	http.Error(w, "Unknown account", http.StatusNotFound)
+}
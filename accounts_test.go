@@ -0,0 +1,229 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLoadAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := loadConfig(writeConfig(t, dir, `
+collectors:
+  geo:
+    aggregate: country
+labels:
+  pull_zone:
+    a: shared
+accounts:
+- name: first
+  bunnycdn:
+    api_key: first_key
+- name: second
+  bunnycdn:
+    api_key: second_key
+  collectors:
+    geo:
+      enabled: false
+  labels:
+    pull_zone:
+      b: own
+`), testBaseConfig)
+	if err != nil {
+		t.Fatal("Unexpected error loading configuration: ", err)
+	}
+	accounts := c.accountConfigs()
+	assertEqual(t, 2, len(accounts), "Accounts")
+	first, second := accounts[0], accounts[1]
+	assertEqual(t, "first", first.Name, "Account name")
+	assertEqual(t, "first_key", first.BunnyCDN.APIKey, "API key of the account")
+	assertEqual(t, "https://bunnycdn.com/api", first.BunnyCDN.APIURI, "API URI from the flags")
+	assertEqual(t, geoAggregateCountry, first.Collectors.Geo.Aggregate, "Geo aggregate from the top level")
+	assertEqual(t, true, first.Collectors.Geo.Enabled, "Geo enabled from the flags")
+	assertEqual(t, false, second.Collectors.Geo.Enabled, "Geo disabled by the account")
+	assertEqual(t, geoAggregateCountry, second.Collectors.Geo.Aggregate, "Geo aggregate kept by the account")
+	assertEqual(t, "shared", second.Labels.PullZone["a"], "Label from the top level")
+	assertEqual(t, "own", second.Labels.PullZone["b"], "Label of the account")
+	assertEqual(t, "", first.Labels.PullZone["b"], "Labels are not shared between accounts")
+
+	single := testBaseConfig
+	accounts = single.accountConfigs()
+	assertEqual(t, 1, len(accounts), "Accounts without accounts configured")
+	assertEqual(t, "", accounts[0].Name, "Name of the single account")
+	assertEqual(t, "flag_key", accounts[0].BunnyCDN.APIKey, "API key of the single account")
+
+	for _, invalid := range []string{
+		"accounts:\n- bunnycdn:\n    api_key: key\n",
+		"accounts:\n- name: a\n- name: a\n",
+		"accounts:\n- name: a\n  accounts:\n  - name: b\n",
+		"accounts:\n- name: a\n  bunnycdn:\n    timeout: 0s\n",
+		"accounts:\n- name: a\n  bunnycdn:\n    api_kye: key\n",
+	} {
+		if _, err := loadConfig(writeConfig(t, dir, invalid), testBaseConfig); err == nil {
+			t.Errorf("Expected an error loading %q", invalid)
+		}
+	}
+}
+
+func TestAccountLabel(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "zone"}]`), nil)
+	defer h.Close()
+
+	reg := prometheus.NewRegistry()
+	for _, name := range []string{"first", "second"} {
+		e, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+		e.account = name
+		accountRegisterer(reg, name).MustRegister(e)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error gathering metrics: ", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "bunnycdn_up" {
+			continue
+		}
+		assertEqual(t, 2, len(mf.GetMetric()), "Up metrics")
+		for i, m := range mf.GetMetric() {
+			assertEqual(t, "account", m.GetLabel()[0].GetName(), "Label name")
+			assertEqual(t, []string{"first", "second"}[i], m.GetLabel()[0].GetValue(), "Account of the metric")
+		}
+		return
+	}
+	t.Fatal("Metric bunnycdn_up not found")
+}
+
+func TestAccountFailing(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "zone"}]`), nil)
+	defer h.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	reg := prometheus.NewRegistry()
+	for name, uri := range map[string]string{"up": h.URL, "down": failing.URL} {
+		e, _ := NewExporter(uri, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+		e.account = name
+		accountRegisterer(reg, name).MustRegister(e)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error gathering metrics: ", err)
+	}
+	up := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "bunnycdn_up" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			up[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	assertEqual(t, 1.0, up["up"], "Up of the working account")
+	assertEqual(t, 0.0, up["down"], "Up of the failing account")
+}
+
+func TestAccountHandlers(t *testing.T) {
+	var top accountHandlers
+	for _, name := range []string{"first", "second"} {
+		name := name
+		top.add(name, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	for query, want := range map[string]string{"": "first", "?account=second": "second"} {
+		rec := httptest.NewRecorder()
+		top.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/top"+query, nil))
+		assertEqual(t, want, rec.Body.String(), "Handler of the account")
+	}
+	rec := httptest.NewRecorder()
+	top.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/top?account=other", nil))
+	assertEqual(t, http.StatusNotFound, rec.Code, "Unknown account")
+}
+
+func TestAccountsReady(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "zone"}]`), nil)
+	defer h.Close()
+	rejected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer rejected.Close()
+
+	up, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	up.account = "up"
+	down, _ := NewExporter(rejected.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	down.account = "down"
+	accounts := accountExporters{up, down}
+
+	rec := httptest.NewRecorder()
+	accounts.serveReady(time.Minute)(rec, httptest.NewRequest("GET", "/-/ready", nil))
+	assertEqual(t, http.StatusOK, rec.Code, "Ready with one account reachable")
+	if !strings.Contains(rec.Body.String(), "down: ") {
+		t.Errorf("Reason of the account down missing from %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	accountExporters{down}.serveReady(time.Minute)(rec, httptest.NewRequest("GET", "/-/ready", nil))
+	assertEqual(t, http.StatusServiceUnavailable, rec.Code, "Ready without any account reachable")
+
+	assertEqual(t, up, accounts.get(""), "Default account")
+	assertEqual(t, down, accounts.get("down"), "Account by name")
+	if accounts.get("other") != nil {
+		t.Error("Unknown account should not be found")
+	}
+}
+
+func TestApplyAccounts(t *testing.T) {
+	first := &accountConfig{Name: "first", config: testBaseConfig}
+	first.BunnyCDN.APIKey = "first_key"
+	second := &accountConfig{Name: "second", config: testBaseConfig}
+	c := testBaseConfig
+	c.accounts = []*accountConfig{first, second}
+
+	var appliers []accountApplier
+	for _, name := range []string{"first", "second", "first"} {
+		e, _ := NewExporter("https://bunnycdn.com/api", "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+		e.account = name
+		appliers = append(appliers, e)
+	}
+	appliers = append(appliers, newPullZoneSource(context.Background(), "second", "", &testBaseConfig))
+	if err := applyAccounts(appliers, &c); err != nil {
+		t.Fatal("Unexpected error applying accounts: ", err)
+	}
+
+	c.accounts = c.accounts[:1]
+	if err := applyAccounts(appliers, &c); err == nil {
+		t.Error("Expected an error removing an account")
+	}
+	third := &accountConfig{Name: "third", config: testBaseConfig}
+	c.accounts = []*accountConfig{first, second, third}
+	if err := applyAccounts(appliers, &c); err == nil {
+		t.Error("Expected an error adding an account")
+	}
+}
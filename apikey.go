@@ -0,0 +1,178 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// keyFile is an API key read from a file, which is read again at every poll
+// so that the key can be rotated without a restart. The content is compared
+// rather than the modification time, which may not change with it, as when
+// Kubernetes swaps the symbolic link of a secret.
+type keyFile struct {
+	path string
+	// stop is closed to stop polling.
+	stop chan struct{}
+
+	mtx sync.RWMutex
+	key string
+}
+
+// apiKey returns the last key read.
+func (f *keyFile) apiKey() string {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	return f.key
+}
+
+// load reads the key. It returns whether it changed since the last time.
+func (f *keyFile) load() (bool, error) {
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	key := string(bytes.TrimSpace(content))
+	if key == "" {
+		return false, fmt.Errorf("API key file %s is empty", f.path)
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if key == f.key {
+		return false, nil
+	}
+	f.key = key
+	return true, nil
+}
+
+// keyFiles polls the API key files in use.
+type keyFiles struct {
+	interval time.Duration
+
+	mtx   sync.Mutex
+	files map[string]*keyFile
+	// labeled are the files that have metrics, including those that could
+	// not be read.
+	labeled map[string]bool
+
+	loadTime   *prometheus.GaugeVec
+	loadErrors *prometheus.CounterVec
+}
+
+func newKeyFiles(interval time.Duration) *keyFiles {
+	return &keyFiles{
+		interval: interval,
+		files:    map[string]*keyFile{},
+		labeled:  map[string]bool{},
+		loadTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_api_key_load_timestamp_seconds",
+			Help:      "Time the API key was last read from its file.",
+		}, []string{"file"}),
+		loadErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_api_key_load_errors_total",
+			Help:      "Number of errors reading the API key from its file.",
+		}, []string{"file"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (k *keyFiles) Describe(ch chan<- *prometheus.Desc) {
+	k.loadTime.Describe(ch)
+	k.loadErrors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (k *keyFiles) Collect(ch chan<- prometheus.Metric) {
+	k.loadTime.Collect(ch)
+	k.loadErrors.Collect(ch)
+}
+
+// get returns the key of a file, which is read and then polled from the
+// first call on.
+func (k *keyFiles) get(path string) (*keyFile, error) {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	if f, ok := k.files[path]; ok {
+		return f, nil
+	}
+
+	k.labeled[path] = true
+	f := &keyFile{path: path, stop: make(chan struct{})}
+	if _, err := f.load(); err != nil {
+		k.loadErrors.WithLabelValues(path).Inc()
+		return nil, err
+	}
+	k.loadTime.WithLabelValues(path).SetToCurrentTime()
+	k.files[path] = f
+	go k.poll(f)
+	return f, nil
+}
+
+func (k *keyFiles) poll(f *keyFile) {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			k.reload(f)
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// retain stops polling the files that are not in paths, and deletes their
+// metrics, once a configuration no longer uses them.
+func (k *keyFiles) retain(paths []string) {
+	keep := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		keep[path] = true
+	}
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	for path := range k.labeled {
+		if keep[path] {
+			continue
+		}
+		if f, ok := k.files[path]; ok {
+			close(f.stop)
+			delete(k.files, path)
+		}
+		delete(k.labeled, path)
+		k.loadTime.DeleteLabelValues(path)
+		k.loadErrors.DeleteLabelValues(path)
+	}
+}
+
+func (k *keyFiles) reload(f *keyFile) {
+	changed, err := f.load()
+	if err != nil {
+		log.Errorf("Unable to read the API key, keeping the previous one: %v", err)
+		k.loadErrors.WithLabelValues(f.path).Inc()
+		return
+	}
+	if changed {
+		log.Infoln("Read new API key from", f.path)
+		k.loadTime.WithLabelValues(f.path).SetToCurrentTime()
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestKeyFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api-key")
+	write := func(key string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(key), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received string
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("AccessKey")
+		w.Write([]byte(`[]`))
+	}))
+	defer h.Close()
+
+	keys := newKeyFiles(time.Hour)
+	now := time.Now()
+	write("first\n", now)
+	c := testBaseConfig
+	c.BunnyCDN.APIKeyFile = path
+	if err := c.resolveAPIKey(keys); err != nil {
+		t.Fatal("Unexpected error reading the API key: ", err)
+	}
+	fetch := fetchHTTPContext(context.Background(), h.URL, c.BunnyCDN.key(), true, time.Second)
+	listPullZones(fetch)
+	assertEqual(t, "first", received, "API key from the file")
+
+	f, _ := keys.get(path)
+	write("second", now.Add(time.Minute))
+	keys.reload(f)
+	listPullZones(fetch)
+	assertEqual(t, "second", received, "API key after rotation")
+
+	// The content changes with the modification time unchanged.
+	write("third", now.Add(time.Minute))
+	keys.reload(f)
+	assertEqual(t, "third", f.apiKey(), "API key changed without its modification time")
+
+	write("", now.Add(2*time.Minute))
+	keys.reload(f)
+	assertEqual(t, "third", f.apiKey(), "API key kept when the file is empty")
+	assertEqual(t, 1.0, testutil.ToFloat64(keys.loadErrors.WithLabelValues(path)), "Load errors")
+
+	c.BunnyCDN.APIKeyFile = filepath.Join(dir, "missing")
+	if err := c.resolveAPIKey(keys); err == nil {
+		t.Fatal("Expected an error reading a missing API key file")
+	}
+}
+
+func TestKeyFilesRetain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kept, dropped := filepath.Join(dir, "kept"), filepath.Join(dir, "dropped")
+	for _, path := range []string{kept, dropped} {
+		if err := ioutil.WriteFile(path, []byte("key"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := newKeyFiles(time.Hour)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(keys)
+	series := func() int {
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, mf := range mfs {
+			n += len(mf.Metric)
+		}
+		return n
+	}
+	keys.get(kept)
+	f, _ := keys.get(dropped)
+	keys.get(filepath.Join(dir, "missing"))
+	assertEqual(t, 3, series(), "Series of the files")
+
+	keys.retain([]string{kept})
+	assertEqual(t, 1, series(), "Series of the files still in use")
+	select {
+	case <-f.stop:
+	default:
+		t.Fatal("Expected the file no longer in use not to be polled")
+	}
+}
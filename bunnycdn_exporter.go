@@ -14,16 +14,18 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
 	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -52,12 +54,35 @@ const (
 )
 
 func newMetric(metricName string, docString string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
-	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", metricName), docString, variableLabels, constLabels)
+	fqName := prometheus.BuildFQName(namespace, "", metricName)
+	d := prometheus.NewDesc(fqName, docString, variableLabels, constLabels)
+	info := metricInfo{Name: fqName, Help: docString}
+	for l := range constLabels {
+		info.Labels = append(info.Labels, l)
+	}
+	sort.Strings(info.Labels)
+	info.Labels = append(info.Labels, variableLabels...)
+	recordMetricInfo(d, info)
+	return d
 }
 
 type bunnyPullZone struct {
-	ID   int64  `json:"Id"`
-	Name string `json:"Name"`
+	ID            int64  `json:"Id"`
+	Name          string `json:"Name"`
+	OriginURL     string `json:"OriginUrl"`
+	AddHostHeader bool   `json:"AddHostHeader"`
+	EnableLogging bool   `json:"EnableLogging"`
+	// MonthlyCharges are the charges of the current month.
+	MonthlyCharges float64         `json:"MonthlyCharges"`
+	Hostnames      []bunnyHostname `json:"Hostnames"`
+}
+
+type bunnyHostname struct {
+	ID               int64  `json:"Id"`
+	Value            string `json:"Value"`
+	ForceSSL         bool   `json:"ForceSSL"`
+	IsSystemHostname bool   `json:"IsSystemHostname"`
+	HasCertificate   bool   `json:"HasCertificate"`
 }
 
 type bunnyLocation struct {
@@ -102,6 +127,29 @@ func extractFromMap(data map[string]float64) float64 {
 	return -1
 }
 
+// latestChartPoint returns the time and value of the most recent point of a
+// statistics chart. The time is zero if no key is a date.
+func latestChartPoint(chart map[string]float64) (time.Time, float64) {
+	var latest time.Time
+	value := extractFromMap(chart)
+	for k, v := range chart {
+		if t, err := parseChartTime(k); err == nil && t.After(latest) {
+			latest, value = t, v
+		}
+	}
+	return latest, value
+}
+
+// parseChartTime parses the keys of statistics charts, which are UTC dates
+// with or without a zone designator.
+func parseChartTime(key string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, key)
+	if err != nil {
+		return time.Parse("2006-01-02T15:04:05", key)
+	}
+	return t, nil
+}
+
 type metricsCollection map[string]*prometheus.Desc
 
 func (m metricsCollection) String() string {
@@ -138,20 +186,46 @@ type Exporter struct {
 	mutex sync.RWMutex
 	fetch func(path string) (io.ReadCloser, error)
 
+	// account is the name of the account, empty if there is only one.
+	account string
+	// ctx cancels the API calls, and raw, if set, keeps their responses.
+	// Both are used by applyConfig.
+	ctx context.Context
+	raw *rawResponses
+
+	geo        geoLimits
+	collectors collectorsConfig
+	filter     zoneFilter
+	zoneLabels map[string]string
+	// chartTimestamps, if set, makes chart metrics carry the date of the
+	// chart point rather than the time of the scrape.
+	chartTimestamps bool
+
+	snapshotMtx sync.RWMutex
+	snapshot    *snapshot
+	// onSnapshot are called with the snapshot of every scrape.
+	onSnapshot []func(*snapshot)
+
+	health apiHealth
+
 	up                                       prometheus.Gauge
 	totalScrapes, totalErrors, totalAPICalls prometheus.Counter
+	geoFolded                                prometheus.Gauge
 	accountMetrics                           metricsCollection
 	pullZoneMetrics                          metricsCollection
 }
 
 // NewExporter returns an initialized Exporter.
-func NewExporter(uri string, bunnyAPIKey string, sslVerify bool, accountMetrics metricsCollection, pullZoneMetrics metricsCollection, timeout time.Duration) (*Exporter, error) {
+func NewExporter(uri string, bunnyAPIKey string, sslVerify bool, accountMetrics metricsCollection, pullZoneMetrics metricsCollection, timeout time.Duration, geo geoLimits) (*Exporter, error) {
 	var fetch func(path string) (io.ReadCloser, error)
 	fetch = fetchHTTP(uri, bunnyAPIKey, sslVerify, timeout)
 
-	return &Exporter{
-		URI:   uri,
-		fetch: fetch,
+	e := &Exporter{
+		URI:        uri,
+		fetch:      fetch,
+		ctx:        context.Background(),
+		geo:        geo,
+		collectors: collectorsConfig{Account: true, PullZones: true, Geo: geoConfig{Enabled: true}},
 		up: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "up",
@@ -172,9 +246,47 @@ func NewExporter(uri string, bunnyAPIKey string, sslVerify bool, accountMetrics
 			Name:      "exporter_api_calls_total",
 			Help:      "Number of calls made to BunnyCDN API",
 		}),
+		geoFolded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_geo_series_folded",
+			Help:      "Number of geo traffic series dropped or folded into an aggregate by the last scrape.",
+		}),
 		accountMetrics:  accountMetrics,
 		pullZoneMetrics: pullZoneMetrics,
-	}, nil
+	}
+	e.health.setFetch(fetch)
+	return e, nil
+}
+
+// applyConfig switches the exporter to a new configuration, once the scrape
+// in progress if any is done.
+func (e *Exporter) applyConfig(c *config) {
+	fetch := fetchHTTPContext(e.ctx, c.BunnyCDN.APIURI, c.BunnyCDN.key(), c.BunnyCDN.SSLVerify, c.BunnyCDN.Timeout)
+	if e.raw != nil {
+		fetch = e.raw.record(e.account, fetch)
+	}
+
+	e.health.setFetch(fetch)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.URI = c.BunnyCDN.APIURI
+	e.fetch = fetch
+	e.geo = c.geoLimits()
+	e.collectors = c.Collectors
+	e.filter = c.filter
+	e.zoneLabels = c.Labels.PullZone
+}
+
+func (e *Exporter) accountName() string {
+	return e.account
+}
+
+// zoneLabel returns the value of the pull_zone label of a pull zone.
+func (e *Exporter) zoneLabel(name string) string {
+	if label, ok := e.zoneLabels[name]; ok {
+		return label
+	}
+	return name
 }
 
 // Describe describes all the metrics ever exported by the BunnyCDN exporter. It
@@ -190,6 +302,7 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.totalScrapes.Desc()
 	ch <- e.totalErrors.Desc()
 	ch <- e.totalAPICalls.Desc()
+	ch <- e.geoFolded.Desc()
 }
 
 // Collect fetches the stats from BunnyCDN API and delivers them
@@ -206,6 +319,17 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	ch <- e.totalScrapes
 	ch <- e.totalErrors
 	ch <- e.totalAPICalls
+	ch <- e.geoFolded
+}
+
+// chartMetric returns the value of a statistics chart as a gauge.
+func (e *Exporter) chartMetric(desc *prometheus.Desc, chart map[string]float64, labelValues ...string) prometheus.Metric {
+	t, value := latestChartPoint(chart)
+	m := prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	if !e.chartTimestamps || t.IsZero() {
+		return m
+	}
+	return prometheus.NewMetricWithTimestamp(t, m)
 }
 
 func getStatisticsForPullZone(fetch func(path string) (io.ReadCloser, error), pz bunnyPullZone) (*bunnyStatistics, error) {
@@ -226,7 +350,7 @@ func rawGetStatistics(fetch func(path string) (io.ReadCloser, error), extraParam
 	p.Add("loadErrors", "true")
 
 	for k, v := range extraParams {
-		p.Add(k, v)
+		p.Set(k, v)
 	}
 
 	body, err := fetch(
@@ -270,7 +394,24 @@ func listPullZones(fetch func(path string) (io.ReadCloser, error)) ([]bunnyPullZ
 	return pullZones, err
 }
 
+// httpStatusError is returned by fetchHTTP when the API answers with a non 2xx
+// status code.
+type httpStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP status %d (%s)", e.StatusCode, e.URL)
+}
+
 func fetchHTTP(uri string, bunnyAPIKey string, sslVerify bool, timeout time.Duration) func(path string) (io.ReadCloser, error) {
+	return fetchHTTPContext(context.Background(), uri, func() string { return bunnyAPIKey }, sslVerify, timeout)
+}
+
+// fetchHTTPContext is fetchHTTP with requests cancelled when ctx is done, and
+// the API key of every request returned by apiKey.
+func fetchHTTPContext(ctx context.Context, uri string, apiKey func() string, sslVerify bool, timeout time.Duration) func(path string) (io.ReadCloser, error) {
 	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: !sslVerify}}
 	client := http.Client{
 		Timeout:   timeout,
@@ -280,7 +421,11 @@ func fetchHTTP(uri string, bunnyAPIKey string, sslVerify bool, timeout time.Dura
 	return func(path string) (io.ReadCloser, error) {
 
 		req, err := http.NewRequest("GET", uri+path, nil)
-		req.Header.Set("AccessKey", bunnyAPIKey)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("AccessKey", apiKey())
 		req.Header.Set("Accept", "application/json")
 
 		resp, err := client.Do(req)
@@ -289,7 +434,7 @@ func fetchHTTP(uri string, bunnyAPIKey string, sslVerify bool, timeout time.Dura
 		}
 		if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
 			resp.Body.Close()
-			return nil, fmt.Errorf("HTTP status %d (%s)", resp.StatusCode, resp.Request.URL)
+			return nil, &httpStatusError{StatusCode: resp.StatusCode, URL: resp.Request.URL.String()}
 		}
 		return resp.Body, nil
 	}
@@ -297,68 +442,100 @@ func fetchHTTP(uri string, bunnyAPIKey string, sslVerify bool, timeout time.Dura
 
 func (e *Exporter) scrape(ch chan<- prometheus.Metric) (up float64) {
 	e.totalScrapes.Inc()
+	e.geoFolded.Set(0)
+
+	snap := &snapshot{
+		Version:     snapshotVersion,
+		CollectedAt: time.Now(),
+		PullZones:   []snapshotPullZone{},
+		Errors:      []snapshotError{},
+	}
+	defer func() {
+		snap.Up = up == 1
+		snap.Duration = time.Since(snap.CollectedAt).Seconds()
+		e.snapshotMtx.Lock()
+		e.snapshot = snap
+		e.snapshotMtx.Unlock()
+		for _, f := range e.onSnapshot {
+			f(snap)
+		}
+	}()
 
-	pullZones, err := listPullZones(e.fetch)
-	e.totalAPICalls.Inc()
+	pullZones, err := e.listPullZones()
 
 	// body, err := e.fetch("/metrics")
 	if err != nil {
 		log.Errorf("Unable to list pull zones: %v", err)
 		e.totalErrors.Inc()
+		snap.addError("list_pull_zones", "", err)
 		return 0
 	}
 
 	var aStatsObj *bunnyStatistics
 
 	for _, pullZone := range pullZones {
+		if !e.collectors.PullZones || !e.filter.match(pullZone.Name) {
+			continue
+		}
+		label := e.zoneLabel(pullZone.Name)
 		stats, err := getStatisticsForPullZone(e.fetch, pullZone)
 		e.totalAPICalls.Inc()
 		if err != nil {
 			log.Errorf("Unable to collect stats: %v", err)
 			e.totalErrors.Inc()
+			snap.addError("pull_zone_statistics", pullZone.Name, err)
+			continue
 		}
 		aStatsObj = stats
+		snap.PullZones = append(snap.PullZones, newSnapshotPullZone(pullZone, stats))
 		for name, metric := range e.pullZoneMetrics {
 			switch name {
 			case metricBandwidthUsed:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(stats.BandwidthUsed), pullZone.Name)
+				ch <- e.chartMetric(metric, stats.BandwidthUsed, label)
 			case metricBandwidthCached:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(stats.BandwidthUsed), pullZone.Name)
+				ch <- e.chartMetric(metric, stats.BandwidthUsed, label)
 			case metricRequestsServer:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(stats.RequestsServed), pullZone.Name)
+				ch <- e.chartMetric(metric, stats.RequestsServed, label)
 			case metricPullRequestsPulled:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(stats.PullRequestsPulled), pullZone.Name)
+				ch <- e.chartMetric(metric, stats.PullRequestsPulled, label)
 			case metricErr3xx:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(stats.Error3Xx), pullZone.Name)
+				ch <- e.chartMetric(metric, stats.Error3Xx, label)
 			case metricErr4xx:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(stats.Error4Xx), pullZone.Name)
+				ch <- e.chartMetric(metric, stats.Error4Xx, label)
 			case metricErr5xx:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(stats.Error5Xx), pullZone.Name)
+				ch <- e.chartMetric(metric, stats.Error5Xx, label)
 			case metricGeoTrafficDist:
-				for _, loc := range stats.trafficLocations() {
-					ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, loc.Requests, pullZone.Name, loc.Region, loc.Location)
+				if !e.collectors.Geo.Enabled {
+					continue
+				}
+				locations, folded := e.geo.apply(stats.trafficLocations())
+				e.geoFolded.Add(float64(folded))
+				for _, loc := range locations {
+					ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, loc.Requests, label, loc.Region, loc.Location)
 				}
 			}
 		}
 	}
 
-	if aStatsObj == nil {
+	if aStatsObj == nil && e.collectors.Account {
 		aStatsObj, err = getStatistics(e.fetch)
 		e.totalAPICalls.Inc()
 
 		if err != nil {
 			log.Errorf("Unable to collect global stats (since no pullzone was found): %v", err)
 			e.totalErrors.Inc()
+			snap.addError("account_statistics", "", err)
 		}
 	}
 
-	if aStatsObj != nil {
+	if aStatsObj != nil && e.collectors.Account {
+		snap.Account = newSnapshotAccount(aStatsObj)
 		for name, metric := range e.accountMetrics {
 			switch name {
 			case metricBalance:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(aStatsObj.UserBalanceHistory))
+				ch <- e.chartMetric(metric, aStatsObj.UserBalanceHistory)
 			case metricStorageUsed:
-				ch <- prometheus.MustNewConstMetric(metric, prometheus.GaugeValue, extractFromMap(aStatsObj.UserStorageUsed))
+				ch <- e.chartMetric(metric, aStatsObj.UserStorageUsed)
 			}
 		}
 	}
@@ -367,39 +544,516 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) (up float64) {
 
 func main() {
 	var (
-		listenAddress  = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9584").String()
+		listenAddress  = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry, unix:///path.sock for a Unix socket. Ignored under systemd socket activation.").Default(":9584").String()
+		socketMode     = kingpin.Flag("web.socket-permissions", "Permissions of the Unix socket listened on, in octal.").Default("0660").String()
+		webConfigFile  = kingpin.Flag("web.config.file", "Path to a configuration file enabling TLS or basic authentication on the web interface.").Default("").String()
+		configFile     = kingpin.Flag("config.file", "Path to a YAML configuration file, reloaded on SIGHUP and POST /-/reload. Its settings take precedence over flags.").Default("").String()
 		metricsPath    = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		debugAddress   = kingpin.Flag("debug.listen-address", "Address to serve profiling and the last raw API responses on (disabled if empty).").Default("").String()
+		shutdownGrace  = kingpin.Flag("shutdown.grace-period", "Time given to scrapes and outputs in progress to complete on SIGTERM before exiting.").Default("15s").Duration()
+		readyWindow    = kingpin.Flag("web.ready-window", "Time within which pull zones must have been listed for /-/ready to report ready.").Default("5m").Duration()
 		bunnyAPIURI    = kingpin.Flag("bunnycdn.api-uri", "API URI on which to get stats from.").Default("https://bunnycdn.com/api").String()
 		bunnyAPIKey    = kingpin.Flag("bunnycdn.api-key", "API key to connect to bunny.").Default(os.Getenv("BUNNYCDN_API_KEY")).String()
+		bunnyKeyFile   = kingpin.Flag("bunnycdn.api-key-file", "File to read the API key from, read again when it changes. Takes precedence over --bunnycdn.api-key.").Default("").String()
+		bunnyKeyPoll   = kingpin.Flag("bunnycdn.api-key-file.poll-interval", "Interval between checks of the API key file for changes.").Default("10s").Duration()
 		bunnySSLVerify = kingpin.Flag("bunnycdn.ssl-verify", "Flag that enables SSL certificate verification for the API URI").Default("true").Bool()
 		bunnyTimeout   = kingpin.Flag("bunnycdn.timeout", "Timeout for trying to get stats from BunnyCDN.").Default("10s").Duration()
+		geoAggregate   = kingpin.Flag("geo.aggregate", "Granularity of the geo traffic distribution: location, country or region.").Default(geoAggregateLocation).Enum(geoAggregateLocation, geoAggregateCountry, geoAggregateRegion)
+		geoTop         = kingpin.Flag("geo.top-locations", "Number of geo locations exported per pull zone, the remainder being summed into location \"other\" (0 for no limit).").Default("0").Int()
+		logsEnabled    = kingpin.Flag("logs.enabled", "Download the access logs of pull zones with logging enabled.").Default("false").Bool()
+		logsAPIURI     = kingpin.Flag("logs.api-uri", "URI of the BunnyCDN logging API.").Default("https://logging.bunnycdn.com").String()
+		logsInterval   = kingpin.Flag("logs.interval", "Interval between access log downloads.").Default("5m").Duration()
+		syslogUDP      = kingpin.Flag("syslog.listen-udp", "Address to receive forwarded access logs on over UDP syslog (disabled if empty).").Default("").String()
+		syslogTCP      = kingpin.Flag("syslog.listen-tcp", "Address to receive forwarded access logs on over TCP syslog (disabled if empty).").Default("").String()
+		syslogQueue    = kingpin.Flag("syslog.queue-size", "Number of syslog messages buffered before dropping.").Default("4096").Int()
+		syslogSources  = kingpin.Flag("syslog.max-sources", "Number of syslog senders counted separately, the others being counted as \"other\".").Default("100").Int()
+		topEnabled     = kingpin.Flag("top.enabled", "Track the most requested paths, referrers and countries from access logs.").Default("false").Bool()
+		topCapacity    = kingpin.Flag("top.capacity", "Number of values tracked per pull zone and dimension.").Default("100").Int()
+		topExported    = kingpin.Flag("top.exported", "Number of top values exported as metrics per pull zone and dimension.").Default("10").Int()
+		topWindow      = kingpin.Flag("top.window", "Interval after which top values are reset (0 to never reset).").Default("1h").Duration()
+		probeEnabled   = kingpin.Flag("probe.enabled", "Periodically probe the hostnames of every pull zone.").Default("false").Bool()
+		probePaths     = kingpin.Flag("probe.path", "Path to probe on every hostname (can be repeated).").Default("/").Strings()
+		probeInterval  = kingpin.Flag("probe.interval", "Interval between probes.").Default("1m").Duration()
+		probeTimeout   = kingpin.Flag("probe.timeout", "Timeout of a single probe.").Default("10s").Duration()
+		probeWorkers   = kingpin.Flag("probe.concurrency", "Number of probes run concurrently.").Default("4").Int()
+		certsEnabled   = kingpin.Flag("certs.enabled", "Periodically check the TLS certificates of custom hostnames.").Default("false").Bool()
+		certsInterval  = kingpin.Flag("certs.interval", "Interval between certificate checks.").Default("1h").Duration()
+		certsTimeout   = kingpin.Flag("certs.timeout", "Timeout of a single TLS handshake.").Default("10s").Duration()
+		certsWorkers   = kingpin.Flag("certs.concurrency", "Number of certificate checks run concurrently.").Default("4").Int()
+		originEnabled  = kingpin.Flag("origin.enabled", "Periodically probe the origin of every pull zone.").Default("false").Bool()
+		originPath     = kingpin.Flag("origin.path", "Path to probe on every origin.").Default("/").String()
+		originHost     = kingpin.Flag("origin.host-header", "Host header sent to origins (defaults to the first custom hostname of zones forwarding it).").Default("").String()
+		originInterval = kingpin.Flag("origin.interval", "Interval between origin probes.").Default("1m").Duration()
+		originTimeout  = kingpin.Flag("origin.timeout", "Timeout of a single origin probe.").Default("10s").Duration()
+		originWorkers  = kingpin.Flag("origin.concurrency", "Number of origin probes run concurrently.").Default("4").Int()
+		pushURL        = kingpin.Flag("push.gateway-url", "Push metrics to this Pushgateway instead of serving them.").Default("").String()
+		pushJob        = kingpin.Flag("push.job", "Job name metrics are pushed under.").Default("bunnycdn_exporter").String()
+		pushGrouping   = kingpin.Flag("push.grouping", "Additional grouping key label, as name=value (can be repeated).").StringMap()
+		pushUsername   = kingpin.Flag("push.username", "Username for basic authentication to the Pushgateway.").Default("").String()
+		pushPassword   = kingpin.Flag("push.password", "Password for basic authentication to the Pushgateway.").Default("").String()
+		pushInterval   = kingpin.Flag("push.interval", "Interval between pushes (0 to push once and exit).").Default("0s").Duration()
+		rwURL          = kingpin.Flag("remote-write.url", "Send metrics to this Prometheus remote write endpoint (disabled if empty).").Default("").String()
+		rwUsername     = kingpin.Flag("remote-write.username", "Username for basic authentication to the remote write endpoint.").Default("").String()
+		rwPassword     = kingpin.Flag("remote-write.password", "Password for basic authentication to the remote write endpoint.").Default("").String()
+		rwLabels       = kingpin.Flag("remote-write.label", "Label added to every sent series, as name=value (can be repeated).").StringMap()
+		rwInterval     = kingpin.Flag("remote-write.interval", "Interval between metric collections sent with remote write.").Default("1m").Duration()
+		rwTimeout      = kingpin.Flag("remote-write.timeout", "Timeout of a remote write request.").Default("30s").Duration()
+		rwBatchSize    = kingpin.Flag("remote-write.batch-size", "Maximum number of samples per remote write request.").Default("500").Int()
+		rwQueueSize    = kingpin.Flag("remote-write.queue-size", "Number of batches queued before dropping new ones.").Default("100").Int()
+		rwMaxRetries   = kingpin.Flag("remote-write.max-retries", "Number of retries of a batch on server errors.").Default("5").Int()
+		rwChartTimes   = kingpin.Flag("remote-write.chart-timestamps", "Timestamp statistics with the date of their chart point rather than the collection time. Receivers reject the repeated timestamps as out of order unless they accept overwriting samples.").Default("true").Bool()
+		otlpEndpoint   = kingpin.Flag("otlp.endpoint", "Export metrics to this OTLP/HTTP endpoint, e.g. http://collector:4318 (disabled if empty).").Default("").String()
+		otlpHeaders    = kingpin.Flag("otlp.header", "Header sent with OTLP exports, as name=value (can be repeated).").StringMap()
+		otlpResource   = kingpin.Flag("otlp.resource-attribute", "Resource attribute of exported metrics, as key=value (can be repeated).").StringMap()
+		otlpInterval   = kingpin.Flag("otlp.interval", "Interval between OTLP exports.").Default("1m").Duration()
+		otlpTimeout    = kingpin.Flag("otlp.timeout", "Timeout of an OTLP export.").Default("10s").Duration()
+		statsdAddress  = kingpin.Flag("statsd.address", "Send metrics to this StatsD server over UDP, e.g. localhost:8125 (disabled if empty).").Default("").String()
+		statsdPrefix   = kingpin.Flag("statsd.prefix", "Prefix of the StatsD metric names.").Default("").String()
+		statsdTags     = kingpin.Flag("statsd.dogstatsd", "Send labels as DogStatsD tags, rather than appending their values to the metric names.").Default("true").Bool()
+		statsdInterval = kingpin.Flag("statsd.flush-interval", "Interval between flushes to StatsD.").Default("1m").Duration()
+		influxURL      = kingpin.Flag("influx.url", "Write metrics to this InfluxDB 2 server, e.g. http://influxdb:8086 (disabled if empty).").Default("").String()
+		influxOrg      = kingpin.Flag("influx.org", "InfluxDB organization to write to.").Default("").String()
+		influxBucket   = kingpin.Flag("influx.bucket", "InfluxDB bucket to write to.").Default("bunnycdn").String()
+		influxToken    = kingpin.Flag("influx.token", "InfluxDB API token.").Default("").String()
+		influxInterval = kingpin.Flag("influx.interval", "Interval between writes to InfluxDB.").Default("1h").Duration()
+		influxTimeout  = kingpin.Flag("influx.timeout", "Timeout of an InfluxDB write.").Default("30s").Duration()
+		influxBatch    = kingpin.Flag("influx.batch-size", "Maximum number of points per InfluxDB write.").Default("5000").Int()
+		influxRetries  = kingpin.Flag("influx.max-retries", "Number of retries of an InfluxDB write on server errors.").Default("5").Int()
+		influxFile     = kingpin.Flag("output.influx-file", "Also append the InfluxDB lines to this file.").Default("").String()
+		notifyURL      = kingpin.Flag("notify.webhook-url", "Post notifications to this webhook (disabled if empty).").Default("").String()
+		notifyFormat   = kingpin.Flag("notify.format", "Format of the notifications: slack or json.").Default(notifyFormatJSON).Enum(notifyFormatSlack, notifyFormatJSON)
+		notifyBalance  = kingpin.Flag("notify.balance-below", "Notify when the account balance is below this amount (0 to disable).").Default("0").Float64()
+		notifyDays     = kingpin.Flag("notify.depletion-days", "Notify when the account balance would run out within this many days (0 to disable).").Default("0").Float64()
+		notifyWindow   = kingpin.Flag("notify.depletion-window", "Period over which the rate of decrease of the balance is measured.").Default("24h").Duration()
+		notifyBudgets  = kingpin.Flag("notify.zone-budget", "Notify when the monthly charges of a pull zone exceed its budget, as name=amount, * for every other zone (can be repeated).").StringMap()
+		notifyResend   = kingpin.Flag("notify.resend-interval", "Interval after which notifications still holding are sent again.").Default("4h").Duration()
+		notifyTimeout  = kingpin.Flag("notify.timeout", "Timeout of a webhook request.").Default("10s").Duration()
+		notifyInterval = kingpin.Flag("notify.interval", "Interval after which the exporter collects by itself to evaluate the notification rules if it was not scraped.").Default("5m").Duration()
+
+		_            = kingpin.Command("serve", "Serve metrics (default).").Default()
+		exportCmd    = kingpin.Command("export", "Export daily statistics of every pull zone, e.g. for finance reports.")
+		exportFrom   = exportCmd.Flag("from", "First day to export, as YYYY-MM-DD (defaults to the first day of the previous month).").Default("").String()
+		exportTo     = exportCmd.Flag("to", "Last day to export, as YYYY-MM-DD (defaults to the last day of the previous month).").Default("").String()
+		exportFormat = exportCmd.Flag("format", "Format of the export: csv or parquet.").Default(exportFormatCSV).Enum(exportFormatCSV, exportFormatParquet)
+		exportOutput = exportCmd.Flag("output", "File to write the export to (- for the standard output).").Short('o').Default("-").String()
+		exportAcct   = exportCmd.Flag("account", "Account of the configuration file to export (defaults to the first one).").Default("").String()
+
+		generateCmd        = kingpin.Command("generate", "Generate monitoring configuration from the metrics of the exporter.")
+		generateDashCmd    = generateCmd.Command("dashboard", "Generate a Grafana dashboard.")
+		generateDashOutput = generateDashCmd.Flag("output", "File to write the dashboard to (- for the standard output).").Short('o').Default("-").String()
+		generateRulesCmd   = generateCmd.Command("rules", "Generate Prometheus recording and alerting rules.")
+		generateRulesOut   = generateRulesCmd.Flag("output", "File to write the rules to (- for the standard output).").Short('o').Default("-").String()
+		ruleBalance        = generateRulesCmd.Flag("balance-below", "Alert when the account balance is below this amount.").Default("10").Float64()
+		ruleErrorRatio     = generateRulesCmd.Flag("5xx-ratio-above", "Alert when the ratio of 5xx errors of a pull zone is above this value.").Default("0.05").Float64()
+		ruleCacheHitDrop   = generateRulesCmd.Flag("cache-hit-drop", "Alert when the cache hit ratio of a pull zone is lower than the day before by this value.").Default("0.2").Float64()
+		ruleScrapeFailFor  = generateRulesCmd.Flag("scrape-failure-for", "Alert when collections have failed for this duration.").Default("15m").String()
 	)
 
 	log.AddFlags(kingpin.CommandLine)
 	kingpin.Version(version.Print("bunnycdn_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+	if *probeWorkers < 1 {
+		kingpin.Fatalf("--probe.concurrency must be at least 1")
+	}
+	if *certsWorkers < 1 {
+		kingpin.Fatalf("--certs.concurrency must be at least 1")
+	}
+	if *originWorkers < 1 {
+		kingpin.Fatalf("--origin.concurrency must be at least 1")
+	}
+	if *notifyInterval <= 0 {
+		kingpin.Fatalf("--notify.interval must be positive")
+	}
+
+	baseCfg := &config{
+		BunnyCDN: bunnyConfig{
+			APIURI:     *bunnyAPIURI,
+			APIKey:     *bunnyAPIKey,
+			APIKeyFile: *bunnyKeyFile,
+			SSLVerify:  *bunnySSLVerify,
+			Timeout:    *bunnyTimeout,
+		},
+		Collectors: collectorsConfig{
+			Account:   true,
+			PullZones: true,
+			Geo:       geoConfig{Enabled: true, Aggregate: *geoAggregate, TopLocations: *geoTop},
+		},
+	}
+	cfg := baseCfg
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile, *baseCfg); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+	keys := newKeyFiles(*bunnyKeyPoll)
+	if err := cfg.resolveAPIKeys(keys); err != nil {
+		log.Fatal(err)
+	}
+	api := cfg.BunnyCDN
+	accounts := cfg.accountConfigs()
+	newAccountExporter := func(a *accountConfig) *Exporter {
+		e, err := NewExporter(a.BunnyCDN.APIURI, a.BunnyCDN.APIKey, a.BunnyCDN.SSLVerify, accountMetrics, pullZoneMetrics, a.BunnyCDN.Timeout, a.geoLimits())
+		if err != nil {
+			log.Fatal(err)
+		}
+		e.account = a.Name
+		return e
+	}
+
+	if command == exportCmd.FullCommand() {
+		a, err := accountNamed(accounts, *exportAcct)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fetch := fetchHTTPContext(context.Background(), a.BunnyCDN.APIURI, a.BunnyCDN.key(), a.BunnyCDN.SSLVerify, a.BunnyCDN.Timeout)
+		if err := runExport(fetch, *exportFrom, *exportTo, *exportFormat, *exportOutput); err != nil {
+			log.Fatalf("Unable to export statistics: %v", err)
+		}
+		return
+	}
+
+	switch command {
+	case generateDashCmd.FullCommand(), generateRulesCmd.FullCommand():
+		e, err := NewExporter(api.APIURI, api.APIKey, api.SSLVerify, accountMetrics, pullZoneMetrics, api.Timeout, geoLimits{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if command == generateDashCmd.FullCommand() {
+			err = writeOutput(*generateDashOutput, func(w io.Writer) error { return runGenerateDashboard(e, w) })
+		} else {
+			err = writeOutput(*generateRulesOut, func(w io.Writer) error {
+				return runGenerateRules(e, ruleThresholds{
+					Balance:       *ruleBalance,
+					ErrorRatio:    *ruleErrorRatio,
+					CacheHitDrop:  *ruleCacheHitDrop,
+					ScrapeFailFor: *ruleScrapeFailFor,
+				}, w)
+			})
+		}
+		if err != nil {
+			log.Fatalf("Unable to generate %s: %v", command, err)
+		}
+		return
+	}
 
 	log.Infoln("Starting bunnycdn_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	exporter, err := NewExporter(*bunnyAPIURI, *bunnyAPIKey, *bunnySSLVerify, accountMetrics, pullZoneMetrics, *bunnyTimeout)
+	ctx := shutdownContext(*shutdownGrace)
+
+	// Every output collects with exporters whose API calls are cancelled on
+	// shutdown, through shutdownGatherer so that the cancelled collection is
+	// not sent, and is waited for by flushing.
+	if *pushURL != "" {
+		reg := prometheus.NewRegistry()
+		for _, a := range accounts {
+			e := newAccountExporter(a)
+			e.ctx = ctx
+			e.applyConfig(&a.config)
+			accountRegisterer(reg, a.Name).MustRegister(e)
+		}
+		reg.MustRegister(version.NewCollector("bunnycdn_exporter"))
+		err := runPush(ctx, pushConfig{
+			URL:      *pushURL,
+			Job:      *pushJob,
+			Grouping: *pushGrouping,
+			Username: *pushUsername,
+			Password: *pushPassword,
+			Interval: *pushInterval,
+		}, shutdownGatherer(ctx, reg))
+		if err != nil {
+			log.Fatalf("Unable to push metrics to %s: %v", *pushURL, err)
+		}
+		return
+	}
+
+	var raw *rawResponses
+	if *debugAddress != "" {
+		raw = newRawResponses()
+	}
+	// served are the exporters of /metrics, and appliers all the exporters
+	// and sources of pull zones, to which reloaded configurations are
+	// applied.
+	var (
+		served   accountExporters
+		appliers []accountApplier
+	)
+	for _, a := range accounts {
+		e := newAccountExporter(a)
+		// Scrapes in progress are cancelled on shutdown.
+		e.ctx = ctx
+		e.raw = raw
+		e.applyConfig(&a.config)
+		served = append(served, e)
+		appliers = append(appliers, e)
+		accountRegisterer(prometheus.DefaultRegisterer, a.Name).MustRegister(e)
+	}
+	prometheus.MustRegister(version.NewCollector("bunnycdn_exporter"))
+	prometheus.MustRegister(keys)
+
+	if *debugAddress != "" {
+		l, err := net.Listen("tcp", *debugAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infoln("Serving debug endpoints on", *debugAddress)
+		go func() { log.Fatal(http.Serve(l, newDebugMux(raw))) }()
+	}
+
+	// The default mux is not used, as net/http/pprof registers itself on it.
+	mux := http.NewServeMux()
+	st := newStatus(*metricsPath, kingpin.CommandLine.Model().Flags)
+	for _, e := range served {
+		e.onSnapshot = append(e.onSnapshot, st.addAccount(e.account, e))
+	}
+
+	if *notifyURL != "" {
+		budgets, err := parseZoneBudgets(*notifyBudgets)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, e := range served {
+			e := e
+			n := newNotifier(*notifyURL, *notifyFormat, notifyRules{
+				BalanceBelow:    *notifyBalance,
+				DepletionDays:   *notifyDays,
+				DepletionWindow: *notifyWindow,
+				ZoneBudgets:     budgets,
+			}, *notifyResend, *notifyTimeout)
+			n.account = e.account
+			accountRegisterer(prometheus.DefaultRegisterer, e.account).MustRegister(n)
+			e.onSnapshot = append(e.onSnapshot, n.notify)
+			go n.run(*notifyInterval, func() { discardCollect(e) })
+		}
+	}
+
+	// flushing waits for outputs to send what they have left on shutdown.
+	var flushing sync.WaitGroup
+	if *rwURL != "" {
+		reg := prometheus.NewRegistry()
+		for _, a := range accounts {
+			e := newAccountExporter(a)
+			e.ctx = ctx
+			e.chartTimestamps = *rwChartTimes
+			e.applyConfig(&a.config)
+			appliers = append(appliers, e)
+			accountRegisterer(reg, a.Name).MustRegister(e)
+		}
+		reg.MustRegister(version.NewCollector("bunnycdn_exporter"))
+
+		w := newRemoteWriter(remoteWriteConfig{
+			URL:        *rwURL,
+			Username:   *rwUsername,
+			Password:   *rwPassword,
+			Labels:     *rwLabels,
+			Timeout:    *rwTimeout,
+			BatchSize:  *rwBatchSize,
+			QueueSize:  *rwQueueSize,
+			MaxRetries: *rwMaxRetries,
+			MinBackoff: time.Second,
+		}, shutdownGatherer(ctx, reg))
+		prometheus.MustRegister(w)
+		flushing.Add(1)
+		go func() {
+			defer flushing.Done()
+			w.run(ctx, *rwInterval)
+		}()
+	}
+
+	if *otlpEndpoint != "" {
+		o, err := newOTLPExporter(otlpConfig{
+			Endpoint:           *otlpEndpoint,
+			Headers:            *otlpHeaders,
+			ResourceAttributes: *otlpResource,
+			Timeout:            *otlpTimeout,
+		}, shutdownGatherer(ctx, prometheus.DefaultGatherer))
+		if err != nil {
+			log.Fatal(err)
+		}
+		prometheus.MustRegister(o)
+		flushing.Add(1)
+		go func() {
+			defer flushing.Done()
+			o.run(ctx, *otlpInterval)
+		}()
+	}
+
+	if *statsdAddress != "" {
+		conn, err := net.Dial("udp", *statsdAddress)
+		if err != nil {
+			log.Fatalf("Unable to connect to StatsD: %v", err)
+		}
+		sink := newStatsdSink(conn, shutdownGatherer(ctx, prometheus.DefaultGatherer), *statsdPrefix, *statsdTags)
+		prometheus.MustRegister(sink)
+		flushing.Add(1)
+		go func() {
+			defer flushing.Done()
+			sink.run(ctx, *statsdInterval)
+		}()
+	}
+
+	if *influxURL != "" || *influxFile != "" {
+		reg := prometheus.NewRegistry()
+		for _, a := range accounts {
+			e := newAccountExporter(a)
+			e.ctx = ctx
+			e.chartTimestamps = true
+			e.applyConfig(&a.config)
+			appliers = append(appliers, e)
+			accountRegisterer(reg, a.Name).MustRegister(e)
+		}
+
+		var file io.Writer
+		if *influxFile != "" {
+			f, err := os.OpenFile(*influxFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				log.Fatalf("Unable to open InfluxDB output file: %v", err)
+			}
+			file = f
+		}
+		w := newInfluxWriter(influxConfig{
+			URL:        *influxURL,
+			Org:        *influxOrg,
+			Bucket:     *influxBucket,
+			Token:      *influxToken,
+			Timeout:    *influxTimeout,
+			BatchSize:  *influxBatch,
+			MaxRetries: *influxRetries,
+			MinBackoff: time.Second,
+		}, shutdownGatherer(ctx, reg), file)
+		prometheus.MustRegister(w)
+		flushing.Add(1)
+		go func() {
+			defer flushing.Done()
+			w.run(ctx, *influxInterval)
+		}()
+	}
+
+	// The other collectors run for every account, and follow reloads through
+	// the source of its pull zones.
+	var (
+		syslogEnabled  = *syslogUDP != "" || *syslogTCP != ""
+		syslogAccounts []syslogAccount
+		top            accountHandlers
+	)
+	for _, a := range accounts {
+		reg := accountRegisterer(prometheus.DefaultRegisterer, a.Name)
+		source := newPullZoneSource(ctx, a.Name, *logsAPIURI, &a.config)
+		appliers = append(appliers, source)
+
+		logMetrics := newLogMetrics()
+		if *logsEnabled || syslogEnabled {
+			reg.MustRegister(logMetrics)
+			if *topEnabled {
+				logMetrics.hitters = newHeavyHitters(*topCapacity, *topExported, *topWindow)
+				reg.MustRegister(logMetrics.hitters)
+				top.add(a.Name, logMetrics.hitters)
+			}
+		}
+
+		if *logsEnabled {
+			logs := newLogCollector(source.list, source.logs, logMetrics)
+			reg.MustRegister(logs)
+			go logs.run(*logsInterval)
+		}
+
+		if *probeEnabled {
+			p := newProber(source.list, uniqueProbePaths(*probePaths), *probeWorkers, *probeTimeout)
+			reg.MustRegister(p)
+			go p.run(*probeInterval)
+		}
+
+		if *originEnabled {
+			o := newOriginChecker(source.list, *originPath, *originHost, *originWorkers, *originTimeout)
+			reg.MustRegister(o)
+			go o.run(*originInterval)
+		}
+
+		if *certsEnabled {
+			c := newCertChecker(source.list, *certsWorkers, *certsTimeout)
+			reg.MustRegister(c)
+			go c.run(*certsInterval)
+		}
+
+		names := &pullZoneNames{
+			pullZones: source.list,
+			refresh:   time.Minute,
+		}
+		syslogAccounts = append(syslogAccounts, syslogAccount{metrics: logMetrics, names: names.lookup})
+	}
+	if *topEnabled && (*logsEnabled || syslogEnabled) {
+		mux.Handle("/debug/top", &top)
+	}
+
+	if syslogEnabled {
+		receiver := newSyslogReceiver(syslogAccounts, *syslogQueue, *syslogSources)
+		prometheus.MustRegister(receiver)
+		go receiver.process()
+
+		if *syslogUDP != "" {
+			conn, err := net.ListenPacket("udp", *syslogUDP)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Infoln("Receiving syslog on UDP", *syslogUDP)
+			go func() { log.Fatal(receiver.serveUDP(conn)) }()
+		}
+		if *syslogTCP != "" {
+			l, err := net.Listen("tcp", *syslogTCP)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Infoln("Receiving syslog on TCP", *syslogTCP)
+			go func() { log.Fatal(receiver.serveTCP(l)) }()
+		}
+	}
+
+	mux.Handle(*metricsPath, promhttp.Handler())
+	mux.HandleFunc("/api/v1/snapshot", served.serveSnapshot)
+	mux.HandleFunc("/-/healthy", serveHealthy)
+	mux.HandleFunc("/-/ready", served.serveReady(*readyWindow))
+	if *configFile != "" {
+		reloader := newConfigReloader(*configFile, *baseCfg, func(c *config) error {
+			if err := c.resolveAPIKeys(keys); err != nil {
+				return err
+			}
+			if err := applyAccounts(appliers, c); err != nil {
+				return err
+			}
+			keys.retain(c.apiKeyFiles())
+			return nil
+		})
+		reloader.lastSuccess.Set(1)
+		reloader.successTimes.SetToCurrentTime()
+		prometheus.MustRegister(reloader)
+		mux.Handle("/-/reload", reloader)
+		go reloader.run()
+	}
+	mux.Handle("/", st)
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		log.Fatalf("Invalid socket permissions %q: %v", *socketMode, err)
+	}
+	l, err := listen(*listenAddress, os.FileMode(mode))
 	if err != nil {
 		log.Fatal(err)
 	}
-	prometheus.MustRegister(exporter)
-	prometheus.MustRegister(version.NewCollector("bunnycdn_exporter"))
+	log.Infoln("Listening on", l.Addr())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := serve(l, server, *webConfigFile); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-	log.Infoln("Listening on", *listenAddress)
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-             <head><title>BunnyCDN Exporter</title></head>
-             <body>
-             <h1>BunnyCDN Exporter</h1>
-             <p><a href='` + *metricsPath + `'>Metrics</a></p>
-             </body>
-             </html>`))
-	})
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	<-ctx.Done()
+	if err := server.Shutdown(context.Background()); err != nil {
+		log.Errorf("Error shutting down the web server: %v", err)
+	}
+	flushing.Wait()
+	log.Infoln("Shutdown complete")
 }
@@ -14,10 +14,15 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 const testSocket = "/tmp/bunnycdnexportertest.sock"
@@ -72,10 +77,30 @@ func TestPullZoneList(t *testing.T) {
 		t.Fatal("Expecting 2 zones but got ", len(pullZones))
 	}
 	if pullZones[0].ID != 34567 || pullZones[0].Name != "pullzonename2" {
-		t.Fatal("Expecting ID 34567 and name pullzonename2 but got " + string(pullZones[0].ID) + " and " + pullZones[0].Name)
+		t.Fatalf("Expecting ID 34567 and name pullzonename2 but got %d and %s", pullZones[0].ID, pullZones[0].Name)
 	}
 }
 
+func TestPullZoneStatisticsError(t *testing.T) {
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pullzone" {
+			w.Write([]byte(`[{"Id": 1, "Name": "zone"}]`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+
+	// The pull zone without statistics is skipped rather than dereferenced.
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal("Unexpected error gathering metrics: ", err)
+	}
+	assertEqual(t, 2.0, testutil.ToFloat64(exporter.totalErrors), "Errors of the pull zone and account statistics")
+}
+
 func TestStatistics(t *testing.T) {
 	respBody := []byte(`{"TotalBandwidthUsed": 28639956,"TotalRequestsServed": 1261,"CacheHitRate": 100,"BandwidthUsedChart": {"2019-05-02T00:00:00Z": 28639956},"BandwidthCachedChart": {"2019-05-02T00:00:00Z": 28639956},"CacheHitRateChart": {"2019-05-02T00:00:00Z": 0},"RequestsServedChart": {"2019-05-02T00:00:00Z": 1261},"PullRequestsPulledChart": {"2019-05-02T00:00:00Z": 0},"UserBalanceHistoryChart": {"2019-05-02T00:37:51": 1000},"UserStorageUsedChart": {"2019-05-02T09:35:02": 0},"GeoTrafficDistribution": {"EU: London, UK": 6040860,"NA: Los Angeles, CA": 5719265,"NA: Atlanta, GA": 2864106,"NA: New York City, NY": 2861460,"EU: Amsterdam, NL": 2566343,"NA: Chicago, IL": 2864106,"EU: Oslo, NO": 2884170,"EU: Frankfurt, DE": 2839646},"Error3xxChart": {"2019-05-02T00:00:00Z": 0},"Error4xxChart": {"2019-05-02T00:00:00Z": 0},"Error5xxChart": {"2019-05-02T00:00:00Z": 0}}`)
 
@@ -89,6 +114,20 @@ func TestStatistics(t *testing.T) {
 	assertEqual(t, float64(28639956), extractFromMap(stats.BandwidthUsed), "Unexpected bandwidth used total.")
 }
 
+func TestChartMetricLatestPoint(t *testing.T) {
+	e, _ := NewExporter("https://bunnycdn.com/api", "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	e.chartTimestamps = true
+	chart := map[string]float64{"2019-05-01T00:00:00Z": 50, "2019-05-02T00:00:00Z": 100}
+	for i := 0; i < 10; i++ {
+		var m dto.Metric
+		if err := e.chartMetric(accountMetrics[metricBalance], chart).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, 100.0, m.GetGauge().GetValue(), "Value of the latest point")
+		assertEqual(t, int64(1556755200000), m.GetTimestampMs(), "Timestamp of the latest point")
+	}
+}
+
 func TestTrafficLocationSplit(t *testing.T) {
 	respBody := []byte(`{"GeoTrafficDistribution": {"EU: London, UK": 6040860.0}}`)
 
@@ -109,3 +148,21 @@ func TestTrafficLocationSplit(t *testing.T) {
 	assertEqual(t, "London, UK", locs[0].Location, "Location description")
 	assertEqual(t, float64(6040860), locs[0].Requests, "Number of requests for location")
 }
+
+func TestFetchCancelled(t *testing.T) {
+	exit := make(chan bool)
+	h := httptest.NewServer(handlerStale(exit))
+	defer h.Close()
+	defer close(exit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fetch := fetchHTTPContext(ctx, h.URL, func() string { return "api_key" }, true, time.Minute)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := listPullZones(fetch); err == nil {
+		t.Fatal("Expected an error listing pull zones once cancelled")
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatal("Cancelled call took ", d)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+type certResult struct {
+	PullZone string
+	Hostname string
+
+	Success  bool
+	NotAfter time.Time
+	Issuer   string
+	SANMatch bool
+}
+
+// certChecker periodically performs a TLS handshake against the custom
+// hostnames of every pull zone, and reports on the certificate they serve.
+// System hostnames are covered by BunnyCDN's own certificate and skipped.
+type certChecker struct {
+	pullZones   func() ([]bunnyPullZone, error)
+	timeout     time.Duration
+	concurrency int
+
+	mtx     sync.RWMutex
+	results []certResult
+
+	successDesc, notAfterDesc, sanMatchDesc, infoDesc *prometheus.Desc
+}
+
+func newCertChecker(pullZones func() ([]bunnyPullZone, error), concurrency int, timeout time.Duration) *certChecker {
+	labels := []string{"pull_zone", "hostname"}
+	return &certChecker{
+		pullZones:    pullZones,
+		timeout:      timeout,
+		concurrency:  concurrency,
+		successDesc:  newMetric("hostname_cert_check_success", "Whether the last TLS handshake with the hostname succeeded.", labels, nil),
+		notAfterDesc: newMetric("hostname_cert_not_after_timestamp_seconds", "Expiry date of the certificate served for the hostname.", labels, nil),
+		sanMatchDesc: newMetric("hostname_cert_san_match", "Whether the certificate served for the hostname is valid for it.", labels, nil),
+		infoDesc:     newMetric("hostname_cert_info", "Issuer of the certificate served for the hostname.", []string{"pull_zone", "hostname", "issuer"}, nil),
+	}
+}
+
+func (c *certChecker) check(pullZone, hostname string) certResult {
+	r := certResult{PullZone: pullZone, Hostname: hostname}
+
+	addr := hostname
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+		addr = net.JoinHostPort(hostname, "443")
+	}
+
+	// Verification is done below, so that expired or mismatching
+	// certificates can still be reported on.
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: c.timeout}, "tcp", addr, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		log.Debugf("TLS handshake with %s failed: %v", hostname, err)
+		return r
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return r
+	}
+	r.Success = true
+	r.NotAfter = certs[0].NotAfter
+	r.Issuer = certs[0].Issuer.CommonName
+	r.SANMatch = certs[0].VerifyHostname(host) == nil
+	return r
+}
+
+func (c *certChecker) checkAll() {
+	pullZones, err := c.pullZones()
+	if err != nil {
+		log.Errorf("Unable to list pull zones to check certificates: %v", err)
+		return
+	}
+
+	var results []certResult
+	var (
+		mtx sync.Mutex
+		wg  sync.WaitGroup
+	)
+	sem := make(chan struct{}, c.concurrency)
+	for _, pz := range pullZones {
+		for _, h := range pz.Hostnames {
+			if h.IsSystemHostname || !h.HasCertificate {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(pullZone, hostname string) {
+				defer wg.Done()
+				r := c.check(pullZone, hostname)
+				<-sem
+				mtx.Lock()
+				results = append(results, r)
+				mtx.Unlock()
+			}(pz.Name, h.Value)
+		}
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].PullZone != results[j].PullZone {
+			return results[i].PullZone < results[j].PullZone
+		}
+		return results[i].Hostname < results[j].Hostname
+	})
+
+	c.mtx.Lock()
+	c.results = results
+	c.mtx.Unlock()
+}
+
+func (c *certChecker) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.checkAll()
+		<-ticker.C
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *certChecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.successDesc
+	ch <- c.notAfterDesc
+	ch <- c.sanMatchDesc
+	ch <- c.infoDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *certChecker) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for _, r := range c.results {
+		if !r.Success {
+			ch <- prometheus.MustNewConstMetric(c.successDesc, prometheus.GaugeValue, 0, r.PullZone, r.Hostname)
+			continue
+		}
+		sanMatch := 0.0
+		if r.SANMatch {
+			sanMatch = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.successDesc, prometheus.GaugeValue, 1, r.PullZone, r.Hostname)
+		ch <- prometheus.MustNewConstMetric(c.notAfterDesc, prometheus.GaugeValue, float64(r.NotAfter.Unix()), r.PullZone, r.Hostname)
+		ch <- prometheus.MustNewConstMetric(c.sanMatchDesc, prometheus.GaugeValue, sanMatch, r.PullZone, r.Hostname)
+		ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1, r.PullZone, r.Hostname, r.Issuer)
+	}
+}
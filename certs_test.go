@@ -0,0 +1,57 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertChecker(t *testing.T) {
+	cdn := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer cdn.Close()
+	hostname := strings.TrimPrefix(cdn.URL, "https://")
+	mismatch := strings.Replace(hostname, "127.0.0.1", "localhost", 1)
+
+	pullZones := []byte(fmt.Sprintf(`[{"Id": 12345,"Name": "pullzonename","Hostnames": [
+		{"Id": 1,"Value": "pullzonename.b-cdn.net","IsSystemHostname": true,"HasCertificate": true},
+		{"Id": 2,"Value": %q,"IsSystemHostname": false,"HasCertificate": true},
+		{"Id": 3,"Value": %q,"IsSystemHostname": false,"HasCertificate": true},
+		{"Id": 4,"Value": "127.0.0.1:1","IsSystemHostname": false,"HasCertificate": true}]}]`, hostname, mismatch))
+	h := newBunny(pullZones, nil)
+	defer h.Close()
+
+	c := newCertChecker(newTestPullZoneSource(h.URL, "").list, 2, time.Second)
+	c.checkAll()
+
+	if len(c.results) != 3 {
+		t.Fatal("Number of checked hostnames: expected: 3, got: ", len(c.results))
+	}
+	// Results are sorted by hostname.
+	assertEqual(t, false, c.results[0].Success, "Handshake success of unreachable hostname")
+
+	r := c.results[1]
+	assertEqual(t, hostname, r.Hostname, "Hostname checked")
+	assertEqual(t, true, r.Success, "Handshake success")
+	assertEqual(t, true, r.SANMatch, "SAN match")
+	assertEqual(t, cdn.Certificate().NotAfter.Unix(), r.NotAfter.Unix(), "Certificate expiry")
+
+	r = c.results[2]
+	assertEqual(t, mismatch, r.Hostname, "Hostname checked")
+	assertEqual(t, false, r.SANMatch, "SAN match for another name")
+}
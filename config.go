@@ -0,0 +1,377 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// config is the configuration file. Settings missing from the file keep the
+// value of the corresponding flag.
+type config struct {
+	BunnyCDN   bunnyConfig      `yaml:"bunnycdn"`
+	Collectors collectorsConfig `yaml:"collectors"`
+	Filters    filtersConfig    `yaml:"filters"`
+	Labels     labelsConfig     `yaml:"labels"`
+	// Accounts, if any, are collected instead of the single account of the
+	// settings above, which are their defaults.
+	Accounts []yaml.MapSlice `yaml:"accounts"`
+
+	filter   zoneFilter
+	accounts []*accountConfig
+}
+
+// accountConfig is the configuration of a named account.
+type accountConfig struct {
+	Name   string `yaml:"name"`
+	config `yaml:",inline"`
+}
+
+type bunnyConfig struct {
+	APIURI string `yaml:"api_uri"`
+	APIKey string `yaml:"api_key"`
+	// APIKeyFile, if set, takes precedence over APIKey.
+	APIKeyFile string        `yaml:"api_key_file"`
+	SSLVerify  bool          `yaml:"ssl_verify"`
+	Timeout    time.Duration `yaml:"timeout"`
+
+	keyFile *keyFile
+}
+
+// key returns the function returning the current API key.
+func (b bunnyConfig) key() func() string {
+	if b.keyFile != nil {
+		return b.keyFile.apiKey
+	}
+	key := b.APIKey
+	return func() string { return key }
+}
+
+// resolveAPIKey reads the API key file if any.
+func (c *config) resolveAPIKey(keys *keyFiles) error {
+	c.BunnyCDN.keyFile = nil
+	if c.BunnyCDN.APIKeyFile == "" {
+		return nil
+	}
+	f, err := keys.get(c.BunnyCDN.APIKeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to read API key: %v", err)
+	}
+	c.BunnyCDN.keyFile = f
+	return nil
+}
+
+// resolveAPIKeys reads the API key files of the top level and the accounts.
+func (c *config) resolveAPIKeys(keys *keyFiles) error {
+	if err := c.resolveAPIKey(keys); err != nil {
+		return err
+	}
+	for _, a := range c.accounts {
+		if err := a.resolveAPIKey(keys); err != nil {
+			return fmt.Errorf("account %q: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// apiKeyFiles returns the API key files of the top level and the accounts.
+func (c *config) apiKeyFiles() []string {
+	var paths []string
+	if c.BunnyCDN.APIKeyFile != "" {
+		paths = append(paths, c.BunnyCDN.APIKeyFile)
+	}
+	for _, a := range c.accounts {
+		if a.BunnyCDN.APIKeyFile != "" {
+			paths = append(paths, a.BunnyCDN.APIKeyFile)
+		}
+	}
+	return paths
+}
+
+type collectorsConfig struct {
+	// Account enables the balance and storage metrics of the account.
+	Account bool `yaml:"account"`
+	// PullZones enables the statistics of pull zones.
+	PullZones bool      `yaml:"pull_zones"`
+	Geo       geoConfig `yaml:"geo"`
+}
+
+type geoConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Aggregate    string `yaml:"aggregate"`
+	TopLocations int    `yaml:"top_locations"`
+}
+
+type filtersConfig struct {
+	PullZones zoneFilterConfig `yaml:"pull_zones"`
+}
+
+// zoneFilterConfig selects pull zones by name. Regular expressions are
+// anchored at both ends.
+type zoneFilterConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+type labelsConfig struct {
+	// PullZone maps the names of pull zones to the value of their pull_zone
+	// label, which defaults to the name.
+	PullZone map[string]string `yaml:"pull_zone"`
+}
+
+// zoneFilter is a compiled zoneFilterConfig.
+type zoneFilter struct {
+	include, exclude []*regexp.Regexp
+}
+
+// match tells whether a pull zone is collected: it must match an include
+// expression if there are any, and no exclude expression.
+func (f zoneFilter) match(name string) bool {
+	included := len(f.include) == 0
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func compileAnchored(exprs []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func (c *config) geoLimits() geoLimits {
+	return geoLimits{Aggregate: c.Collectors.Geo.Aggregate, TopLocations: c.Collectors.Geo.TopLocations}
+}
+
+// validate checks the configuration and compiles its filters.
+func (c *config) validate() error {
+	u, err := url.Parse(c.BunnyCDN.APIURI)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid bunnycdn.api_uri %q", c.BunnyCDN.APIURI)
+	}
+	if c.BunnyCDN.Timeout <= 0 {
+		return errors.New("bunnycdn.timeout must be positive")
+	}
+	switch c.Collectors.Geo.Aggregate {
+	case geoAggregateLocation, geoAggregateCountry, geoAggregateRegion:
+	default:
+		return fmt.Errorf("invalid collectors.geo.aggregate %q", c.Collectors.Geo.Aggregate)
+	}
+	if c.Collectors.Geo.TopLocations < 0 {
+		return errors.New("collectors.geo.top_locations must not be negative")
+	}
+	if c.filter.include, err = compileAnchored(c.Filters.PullZones.Include); err != nil {
+		return fmt.Errorf("invalid filters.pull_zones.include: %v", err)
+	}
+	if c.filter.exclude, err = compileAnchored(c.Filters.PullZones.Exclude); err != nil {
+		return fmt.Errorf("invalid filters.pull_zones.exclude: %v", err)
+	}
+	values := map[string]string{}
+	for name, value := range c.Labels.PullZone {
+		if value == "" {
+			return fmt.Errorf("empty label for pull zone %q", name)
+		}
+		if other, ok := values[value]; ok {
+			return fmt.Errorf("pull zones %q and %q have the same label %q", name, other, value)
+		}
+		values[value] = name
+	}
+	return nil
+}
+
+// loadConfig reads a configuration file over base, the configuration of the
+// flags. Unknown fields are errors.
+func loadConfig(path string, base config) (*config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := base
+	if err := yaml.UnmarshalStrict(content, &c); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	if err := c.loadAccounts(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// loadAccounts decodes the accounts over the settings of the top level.
+func (c *config) loadAccounts() error {
+	names := map[string]bool{}
+	for i, raw := range c.Accounts {
+		a := &accountConfig{config: *c}
+		a.Accounts, a.accounts = nil, nil
+		// Maps would otherwise be shared with the top level and the other
+		// accounts.
+		a.Labels.PullZone = map[string]string{}
+		for k, v := range c.Labels.PullZone {
+			a.Labels.PullZone[k] = v
+		}
+
+		content, err := yaml.Marshal(raw)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(content, a); err != nil {
+			return fmt.Errorf("account %d: %v", i+1, err)
+		}
+		if a.Name == "" {
+			return fmt.Errorf("account %d has no name", i+1)
+		}
+		if names[a.Name] {
+			return fmt.Errorf("duplicate account %q", a.Name)
+		}
+		names[a.Name] = true
+		if len(a.Accounts) > 0 {
+			return fmt.Errorf("account %q: accounts cannot be nested", a.Name)
+		}
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("account %q: %v", a.Name, err)
+		}
+		c.accounts = append(c.accounts, a)
+	}
+	return nil
+}
+
+// accountConfigs returns the accounts to collect: the named accounts if
+// any, else a single account without a name, of the top level settings.
+func (c *config) accountConfigs() []*accountConfig {
+	if len(c.accounts) == 0 {
+		return []*accountConfig{{config: *c}}
+	}
+	accounts := make([]*accountConfig, 0, len(c.accounts))
+	for _, a := range c.accounts {
+		copied := *a
+		accounts = append(accounts, &copied)
+	}
+	return accounts
+}
+
+// configReloader reloads the configuration file on SIGHUP and POST requests,
+// keeping the previous configuration when the file is invalid.
+type configReloader struct {
+	path  string
+	base  config
+	apply func(*config) error
+
+	mtx                       sync.Mutex
+	lastSuccess, successTimes prometheus.Gauge
+}
+
+func newConfigReloader(path string, base config, apply func(*config) error) *configReloader {
+	return &configReloader{
+		path:  path,
+		base:  base,
+		apply: apply,
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+		successTimes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (r *configReloader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.lastSuccess.Desc()
+	ch <- r.successTimes.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (r *configReloader) Collect(ch chan<- prometheus.Metric) {
+	ch <- r.lastSuccess
+	ch <- r.successTimes
+}
+
+func (r *configReloader) reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	c, err := loadConfig(r.path, r.base)
+	if err == nil {
+		err = r.apply(c)
+	}
+	if err != nil {
+		log.Errorf("Error reloading configuration, keeping the previous one: %v", err)
+		r.lastSuccess.Set(0)
+		return err
+	}
+	r.lastSuccess.Set(1)
+	r.successTimes.SetToCurrentTime()
+	log.Infoln("Loaded configuration", r.path)
+	return nil
+}
+
+// run reloads the configuration on every SIGHUP.
+func (r *configReloader) run() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		r.reload()
+	}
+}
+
+// ServeHTTP reloads the configuration on POST /-/reload.
+func (r *configReloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("Configuration reloaded\n"))
+}
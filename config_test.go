@@ -0,0 +1,154 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testBaseConfig = config{
+	BunnyCDN:   bunnyConfig{APIURI: "https://bunnycdn.com/api", APIKey: "flag_key", SSLVerify: true, Timeout: time.Second},
+	Collectors: collectorsConfig{Account: true, PullZones: true, Geo: geoConfig{Enabled: true, Aggregate: geoAggregateLocation}},
+}
+
+func writeConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := loadConfig(writeConfig(t, dir, `
+bunnycdn:
+  api_key: file_key
+collectors:
+  geo:
+    aggregate: country
+filters:
+  pull_zones:
+    include: [web-.*]
+`), testBaseConfig)
+	if err != nil {
+		t.Fatal("Unexpected error loading configuration: ", err)
+	}
+	assertEqual(t, "file_key", c.BunnyCDN.APIKey, "API key from the file")
+	assertEqual(t, "https://bunnycdn.com/api", c.BunnyCDN.APIURI, "API URI from the flags")
+	assertEqual(t, geoAggregateCountry, c.Collectors.Geo.Aggregate, "Geo aggregate from the file")
+	assertEqual(t, true, c.Collectors.Geo.Enabled, "Geo enabled from the flags")
+	assertEqual(t, true, c.filter.match("web-assets"), "Included pull zone")
+	assertEqual(t, false, c.filter.match("my-web-assets"), "Filters are anchored")
+	assertEqual(t, "flag_key", testBaseConfig.BunnyCDN.APIKey, "Flags are left unchanged")
+
+	for _, invalid := range []string{
+		"bunnycdn:\n  api_kye: key\n",
+		"bunnycdn:\n  timeout: 0s\n",
+		"collectors:\n  geo:\n    aggregate: planet\n",
+		"filters:\n  pull_zones:\n    exclude: ['(']\n",
+		"labels:\n  pull_zone:\n    a: web\n    b: web\n",
+	} {
+		if _, err := loadConfig(writeConfig(t, dir, invalid), testBaseConfig); err == nil {
+			t.Errorf("Expected an error loading %q", invalid)
+		}
+	}
+}
+
+func TestExporterConfig(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "alpha"}, {"Id": 2, "Name": "beta"}, {"Id": 3, "Name": "gamma"}]`), []byte(`{
+		"RequestsServedChart": {"2019-05-02T00:00:00Z": 100},
+		"UserBalanceHistoryChart": {"2019-05-02T00:00:00": 1000},
+		"GeoTrafficDistribution": {"EU: London, GB": 10}
+	}`))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+
+	c := testBaseConfig
+	c.BunnyCDN.APIURI = h.URL
+	c.Collectors.Account = false
+	c.Collectors.Geo.Enabled = false
+	c.Filters.PullZones = zoneFilterConfig{Include: []string{"alpha|beta"}, Exclude: []string{"beta"}}
+	c.Labels.PullZone = map[string]string{"alpha": "Alpha"}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	exporter.applyConfig(&c)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zones []string
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "bunnycdn_requests_served_total":
+			for _, m := range mf.GetMetric() {
+				zones = append(zones, m.GetLabel()[0].GetValue())
+			}
+		case "bunnycdn_account_balance", "bunnycdn_requests_served":
+			t.Errorf("Disabled metric %s was collected", mf.GetName())
+		}
+	}
+	sort.Strings(zones)
+	assertEqual(t, "Alpha", strings.Join(zones, ","), "Collected pull zones")
+}
+
+func TestConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var applied *config
+	path := writeConfig(t, dir, "labels:\n  pull_zone:\n    a: first\n")
+	r := newConfigReloader(path, testBaseConfig, func(c *config) error {
+		applied = c
+		return nil
+	})
+
+	reload := func(method string) int {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, "/-/reload", nil))
+		return rec.Code
+	}
+	assertEqual(t, http.StatusMethodNotAllowed, reload("GET"), "Status of GET")
+	assertEqual(t, http.StatusOK, reload("POST"), "Status of a valid reload")
+	assertEqual(t, "first", applied.Labels.PullZone["a"], "Applied configuration")
+	assertEqual(t, 1.0, testutil.ToFloat64(r.lastSuccess), "Last reload successful")
+
+	writeConfig(t, dir, "labels:\n  pull_zone:\n    a: ''\n")
+	assertEqual(t, http.StatusInternalServerError, reload("POST"), "Status of an invalid reload")
+	assertEqual(t, "first", applied.Labels.PullZone["a"], "Configuration kept")
+	assertEqual(t, 0.0, testutil.ToFloat64(r.lastSuccess), "Last reload failed")
+}
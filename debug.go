@@ -0,0 +1,158 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// secretField matches the fields of API responses whose value is redacted,
+// such as the token authentication key of pull zones.
+var secretField = regexp.MustCompile(`(?i)key|secret|password|token`)
+
+// rawResponse is an API response as dumped on the debug listener.
+type rawResponse struct {
+	Time  time.Time       `json:"time"`
+	Error string          `json:"error,omitempty"`
+	Body  json.RawMessage `json:"body,omitempty"`
+}
+
+// rawResponses keeps the last response of every API endpoint.
+type rawResponses struct {
+	mtx       sync.Mutex
+	responses map[string]rawResponse
+}
+
+func newRawResponses() *rawResponses {
+	return &rawResponses{responses: map[string]rawResponse{}}
+}
+
+// record wraps fetch to keep its responses. They are kept by rawResponseKey,
+// prefixed with the name of the account if any.
+func (r *rawResponses) record(account string, fetch func(path string) (io.ReadCloser, error)) func(path string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		resp := rawResponse{Time: time.Now()}
+		body, err := fetch(path)
+		if err == nil {
+			var content []byte
+			content, err = ioutil.ReadAll(body)
+			body.Close()
+			resp.Body = redactJSON(content)
+			body = ioutil.NopCloser(bytes.NewReader(content))
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+
+		r.mtx.Lock()
+		r.responses[account+rawResponseKey(path)] = resp
+		r.mtx.Unlock()
+		return body, err
+	}
+}
+
+// rawResponseKey returns the endpoint of an API path, with its pull zone if
+// any. Other query parameters, such as the dates of statistics, are left out
+// so that a response replaces the previous one of the same endpoint.
+func rawResponseKey(path string) string {
+	u, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	if pullZone := u.Query().Get("pullZone"); pullZone != "" {
+		return u.Path + "?pullZone=" + pullZone
+	}
+	return u.Path
+}
+
+// redactJSON redacts the secret fields of a JSON document. Documents that are
+// not valid JSON are left out altogether, as they cannot be redacted.
+func redactJSON(content []byte) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal(content, &v); err != nil {
+		b, _ := json.Marshal("<invalid JSON redacted>")
+		return b
+	}
+	b, _ := json.Marshal(redact(v))
+	return b
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if secretField.MatchString(k) {
+				if s, ok := field.(string); ok && s == "" {
+					continue
+				}
+				if field != nil {
+					v[k] = "<redacted>"
+				}
+				continue
+			}
+			v[k] = redact(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
+
+// ServeHTTP serves the last responses by API endpoint, or only the one of the
+// path parameter.
+func (r *rawResponses) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mtx.Lock()
+	var v interface{} = r.responses
+	if path := req.URL.Query().Get("path"); path != "" {
+		resp, ok := r.responses[path]
+		if !ok {
+			r.mtx.Unlock()
+			http.NotFound(w, req)
+			return
+		}
+		v = resp
+	}
+	content, err := json.MarshalIndent(v, "", "  ")
+	r.mtx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// newDebugMux returns the handlers of the debug listener. They are kept off
+// the listener of the metrics, as profiles and API responses are not for
+// whoever can scrape. The command line is not served, as it holds secrets.
+func newDebugMux(raw *rawResponses) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/api/responses", raw)
+	return mux
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRawResponses(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "zone", "ZoneSecurityKey": "s3cr3t", "AWSSigningKey": "", "Hostnames": [{"Value": "a.b-cdn.net", "Token": "t0k3n"}]}]`), []byte(`{}`))
+	defer h.Close()
+
+	raw := newRawResponses()
+	fetch := raw.record("", fetchHTTP(h.URL, "api_key", true, time.Second))
+	pullZones, err := listPullZones(fetch)
+	if err != nil {
+		t.Fatal("Unexpected error listing pull zones: ", err)
+	}
+	assertEqual(t, "zone", pullZones[0].Name, "Pull zones are decoded from the recorded response")
+
+	mux := newDebugMux(raw)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/api/responses?path=/pullzone", nil))
+	assertEqual(t, http.StatusOK, rec.Code, "Status of a recorded path")
+	body := rec.Body.String()
+	assertEqual(t, false, strings.Contains(body, "s3cr3t"), "Secret field is redacted")
+	assertEqual(t, false, strings.Contains(body, "t0k3n"), "Nested secret field is redacted")
+	assertEqual(t, true, strings.Contains(body, "a.b-cdn.net"), "Other fields are kept")
+
+	var resp rawResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal("Unable to decode response: ", err)
+	}
+	var zones []map[string]interface{}
+	json.Unmarshal(resp.Body, &zones)
+	assertEqual(t, "<redacted>", zones[0]["ZoneSecurityKey"], "Redacted value")
+	assertEqual(t, "", zones[0]["AWSSigningKey"], "Empty secrets are left as is")
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/api/responses?path=/unknown", nil))
+	assertEqual(t, http.StatusNotFound, rec.Code, "Status of an unknown path")
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/heap", nil))
+	assertEqual(t, http.StatusOK, rec.Code, "Profiling on the debug mux")
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/cmdline", nil))
+	assertEqual(t, false, strings.Contains(rec.Body.String(), "test.run"), "Command line is not served")
+}
+
+func TestRawResponsesByEndpoint(t *testing.T) {
+	raw := newRawResponses()
+	fetch := raw.record("", func(path string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(`{}`)), nil
+	})
+	fetch("/statistics?dateFrom=2019-05-01&dateTo=2019-05-01&pullZone=1")
+	fetch("/statistics?dateFrom=2019-05-02&dateTo=2019-05-02&pullZone=1")
+	fetch("/statistics?dateFrom=2019-05-02&dateTo=2019-05-02&pullZone=2")
+	fetch("/pullzone")
+
+	assertEqual(t, 3, len(raw.responses), "Responses kept")
+	if _, ok := raw.responses["/statistics?pullZone=1"]; !ok {
+		t.Fatal("Statistics should be kept by pull zone")
+	}
+}
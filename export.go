@@ -0,0 +1,203 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	exportFormatCSV     = "csv"
+	exportFormatParquet = "parquet"
+)
+
+var exportHeader = []string{
+	"date",
+	"pull_zone_id",
+	"pull_zone",
+	"bandwidth_used_bytes",
+	"bandwidth_cached_bytes",
+	"requests_served",
+	"pull_requests_pulled",
+	"monthly_charges",
+}
+
+// exportRow holds the statistics of a pull zone for a day.
+type exportRow struct {
+	Date               time.Time
+	PullZoneID         int64
+	PullZone           string
+	BandwidthUsed      float64
+	BandwidthCached    float64
+	RequestsServed     float64
+	PullRequestsPulled float64
+	// MonthlyCharges are the charges of the pull zone for the current month
+	// at export time, as BunnyCDN does not report them by day.
+	MonthlyCharges float64
+}
+
+// sumDay returns the sum of the chart points of a day.
+func sumDay(chart map[string]float64, day time.Time) float64 {
+	var sum float64
+	for k, v := range chart {
+		t, err := parseChartTime(k)
+		if err == nil && t.UTC().Format("2006-01-02") == day.Format("2006-01-02") {
+			sum += v
+		}
+	}
+	return sum
+}
+
+// exportRows fetches the statistics of every pull zone between two dates,
+// and returns a row per pull zone and day.
+func exportRows(fetch func(path string) (io.ReadCloser, error), from, to time.Time) ([]exportRow, error) {
+	pullZones, err := listPullZones(fetch)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pull zones: %v", err)
+	}
+
+	var rows []exportRow
+	for _, pz := range pullZones {
+		stats, err := rawGetStatistics(fetch, map[string]string{
+			"pullZone": fmt.Sprintf("%d", pz.ID),
+			"dateFrom": from.Format("2006-01-02"),
+			"dateTo":   to.Format("2006-01-02"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get statistics of pull zone %s: %v", pz.Name, err)
+		}
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			rows = append(rows, exportRow{
+				Date:               day,
+				PullZoneID:         pz.ID,
+				PullZone:           pz.Name,
+				BandwidthUsed:      sumDay(stats.BandwidthUsed, day),
+				BandwidthCached:    sumDay(stats.BandwidthCached, day),
+				RequestsServed:     sumDay(stats.RequestsServed, day),
+				PullRequestsPulled: sumDay(stats.PullRequestsPulled, day),
+				MonthlyCharges:     pz.MonthlyCharges,
+			})
+		}
+	}
+	return rows, nil
+}
+
+func writeExportCSV(w io.Writer, rows []exportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write(exportHeader)
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Date.Format("2006-01-02"),
+			strconv.FormatInt(r.PullZoneID, 10),
+			r.PullZone,
+			f(r.BandwidthUsed),
+			f(r.BandwidthCached),
+			f(r.RequestsServed),
+			f(r.PullRequestsPulled),
+			f(r.MonthlyCharges),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeExportParquet(w io.Writer, rows []exportRow) error {
+	var (
+		dates                        = make([]time.Time, len(rows))
+		ids                          = make([]int64, len(rows))
+		names                        = make([]string, len(rows))
+		used, cached, served, pulled = make([]float64, len(rows)), make([]float64, len(rows)), make([]float64, len(rows)), make([]float64, len(rows))
+		charges                      = make([]float64, len(rows))
+	)
+	for i, r := range rows {
+		dates[i], ids[i], names[i] = r.Date, r.PullZoneID, r.PullZone
+		used[i], cached[i], served[i], pulled[i] = r.BandwidthUsed, r.BandwidthCached, r.RequestsServed, r.PullRequestsPulled
+		charges[i] = r.MonthlyCharges
+	}
+	return writeParquet(w, []parquetColumn{
+		{exportHeader[0], dates},
+		{exportHeader[1], ids},
+		{exportHeader[2], names},
+		{exportHeader[3], used},
+		{exportHeader[4], cached},
+		{exportHeader[5], served},
+		{exportHeader[6], pulled},
+		{exportHeader[7], charges},
+	}, len(rows))
+}
+
+// exportRange parses the dates of an export. It defaults to the previous
+// month.
+func exportRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start, end := firstOfMonth.AddDate(0, -1, 0), firstOfMonth.AddDate(0, 0, -1)
+	var err error
+	if from != "" {
+		if start, err = time.Parse("2006-01-02", from); err != nil {
+			return start, end, fmt.Errorf("invalid start date: %v", err)
+		}
+	}
+	if to != "" {
+		if end, err = time.Parse("2006-01-02", to); err != nil {
+			return start, end, fmt.Errorf("invalid end date: %v", err)
+		}
+	}
+	if end.Before(start) {
+		return start, end, fmt.Errorf("end date %s is before start date %s", to, from)
+	}
+	return start, end, nil
+}
+
+// writeOutput calls write with the file at path, or the standard output if
+// path is "-".
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runExport writes the statistics of every pull zone between two dates to
+// output, or to the standard output if it is "-".
+func runExport(fetch func(path string) (io.ReadCloser, error), from, to, format, output string) error {
+	start, end, err := exportRange(from, to, time.Now())
+	if err != nil {
+		return err
+	}
+	rows, err := exportRows(fetch, start, end)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(output, func(w io.Writer) error {
+		if format == exportFormatParquet {
+			return writeExportParquet(w, rows)
+		}
+		return writeExportCSV(w, rows)
+	})
+}
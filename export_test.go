@@ -0,0 +1,69 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExportRange(t *testing.T) {
+	now := time.Date(2019, 3, 15, 10, 0, 0, 0, time.UTC)
+	from, to, err := exportRange("", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "2019-02-01", from.Format("2006-01-02"), "Default start date")
+	assertEqual(t, "2019-02-28", to.Format("2006-01-02"), "Default end date")
+
+	if _, _, err := exportRange("2019-05-02", "2019-05-01", now); err == nil {
+		t.Fatal("Expected an error for an end date before the start date")
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var query string
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pullzone" {
+			w.Write([]byte(`[{"Id": 1, "Name": "zone", "MonthlyCharges": 1.5}]`))
+			return
+		}
+		query = r.URL.RawQuery
+		w.Write([]byte(`{
+			"BandwidthUsedChart": {"2019-05-01T00:00:00Z": 100, "2019-05-02T00:00:00Z": 200},
+			"RequestsServedChart": {"2019-05-01T00:00:00Z": 10, "2019-05-02T00:00:00Z": 20}
+		}`))
+	}))
+	defer h.Close()
+
+	from, to, _ := exportRange("2019-05-01", "2019-05-02", time.Now())
+	rows, err := exportRows(fetchHTTP(h.URL, "api_key", true, time.Second), from, to)
+	if err != nil {
+		t.Fatal("Unexpected error exporting statistics: ", err)
+	}
+	assertEqual(t, "dateFrom=2019-05-01&dateTo=2019-05-02&loadErrors=true&pullZone=1", query, "Statistics query")
+
+	var out bytes.Buffer
+	if err := writeExportCSV(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := `date,pull_zone_id,pull_zone,bandwidth_used_bytes,bandwidth_cached_bytes,requests_served,pull_requests_pulled,monthly_charges
+2019-05-01,1,zone,100,0,10,0,1.5
+2019-05-02,1,zone,200,0,20,0,1.5
+`
+	assertEqual(t, expected, out.String(), "CSV export")
+}
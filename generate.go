@@ -0,0 +1,367 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// metricInfo describes a metric of the exporter.
+type metricInfo struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+func (m metricInfo) hasLabel(name string) bool {
+	for _, l := range m.Labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The client library does not expose the content of descriptors, so
+// newMetric records it.
+var (
+	metricInfosMtx sync.Mutex
+	metricInfos    = map[*prometheus.Desc]metricInfo{}
+)
+
+func recordMetricInfo(d *prometheus.Desc, info metricInfo) {
+	metricInfosMtx.Lock()
+	defer metricInfosMtx.Unlock()
+	metricInfos[d] = info
+}
+
+func describeMetric(d *prometheus.Desc) (metricInfo, error) {
+	metricInfosMtx.Lock()
+	defer metricInfosMtx.Unlock()
+	info, ok := metricInfos[d]
+	if !ok {
+		return metricInfo{}, fmt.Errorf("metric descriptor %s was not made by newMetric", d)
+	}
+	return info, nil
+}
+
+// describeMetrics returns the metrics of the collections, merging the
+// descriptors sharing a name, sorted by name.
+func describeMetrics(collections ...metricsCollection) ([]metricInfo, error) {
+	byName := map[string]metricInfo{}
+	for _, c := range collections {
+		for _, d := range c {
+			m, err := describeMetric(d)
+			if err != nil {
+				return nil, err
+			}
+			if prev, ok := byName[m.Name]; ok {
+				for _, l := range m.Labels {
+					if !prev.hasLabel(l) {
+						prev.Labels = append(prev.Labels, l)
+					}
+				}
+				m = prev
+			}
+			byName[m.Name] = m
+		}
+	}
+	metrics := make([]metricInfo, 0, len(byName))
+	for _, m := range byName {
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics, nil
+}
+
+// collectorName returns the name of the single metric of a collector, such
+// as a gauge or counter.
+func collectorName(c prometheus.Collector) (string, error) {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		return "", err
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		return "", err
+	}
+	if len(mfs) != 1 {
+		return "", fmt.Errorf("collector has %d metrics instead of 1", len(mfs))
+	}
+	return mfs[0].GetName(), nil
+}
+
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Tags          []string          `json:"tags"`
+	Editable      bool              `json:"editable"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          map[string]string `json:"time"`
+	Templating    struct {
+		List []grafanaVariable `json:"list"`
+	} `json:"templating"`
+	Panels []grafanaPanel `json:"panels"`
+}
+
+type grafanaVariable struct {
+	Name       string `json:"name"`
+	Label      string `json:"label"`
+	Type       string `json:"type"`
+	Datasource string `json:"datasource,omitempty"`
+	Query      string `json:"query"`
+	Refresh    int    `json:"refresh,omitempty"`
+	Multi      bool   `json:"multi"`
+	IncludeAll bool   `json:"includeAll"`
+}
+
+type grafanaPanel struct {
+	ID          int             `json:"id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Type        string          `json:"type"`
+	Datasource  string          `json:"datasource"`
+	GridPos     map[string]int  `json:"gridPos"`
+	Targets     []grafanaTarget `json:"targets"`
+}
+
+type grafanaTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	RefID        string `json:"refId"`
+}
+
+// metricTarget returns the query graphing a metric, aggregated by its most
+// relevant labels. Pull zones are told apart by the account label, which
+// only the metrics of named accounts have.
+func metricTarget(m metricInfo) grafanaTarget {
+	const selector = `{pull_zone=~"$pull_zone"}`
+	switch {
+	case m.hasLabel("location"):
+		return grafanaTarget{Expr: "topk(10, sum by (location) (" + m.Name + selector + "))", LegendFormat: "{{location}}"}
+	case m.hasLabel("code"):
+		return grafanaTarget{Expr: "sum by (account, pull_zone, code) (" + m.Name + selector + ")", LegendFormat: "{{pull_zone}} {{code}} {{account}}"}
+	case m.hasLabel("pull_zone"):
+		return grafanaTarget{Expr: "sum by (account, pull_zone) (" + m.Name + selector + ")", LegendFormat: "{{pull_zone}} {{account}}"}
+	}
+	return grafanaTarget{Expr: m.Name}
+}
+
+// generateDashboard returns a Grafana dashboard with a graph per metric of the
+// exporter, and one for the health of the exporter.
+func generateDashboard(metrics []metricInfo, upMetric, errorsMetric string) *grafanaDashboard {
+	var pullZoneMetric string
+	for _, m := range metrics {
+		if m.hasLabel("pull_zone") {
+			pullZoneMetric = m.Name
+			break
+		}
+	}
+
+	d := &grafanaDashboard{
+		Title:         "BunnyCDN",
+		UID:           "bunnycdn",
+		Tags:          []string{"bunnycdn"},
+		Editable:      true,
+		SchemaVersion: 16,
+		Refresh:       "5m",
+		Time:          map[string]string{"from": "now-24h", "to": "now"},
+	}
+	d.Templating.List = []grafanaVariable{
+		{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		{
+			Name:       "pull_zone",
+			Label:      "Pull zone",
+			Type:       "query",
+			Datasource: "$datasource",
+			Query:      "label_values(" + pullZoneMetric + ", pull_zone)",
+			Refresh:    2,
+			Multi:      true,
+			IncludeAll: true,
+		},
+	}
+	add := func(title, description string, targets ...grafanaTarget) {
+		for i := range targets {
+			targets[i].RefID = string(rune('A' + i))
+		}
+		n := len(d.Panels)
+		d.Panels = append(d.Panels, grafanaPanel{
+			ID:          n + 1,
+			Title:       title,
+			Description: description,
+			Type:        "graph",
+			Datasource:  "$datasource",
+			GridPos:     map[string]int{"x": n % 2 * 12, "y": n / 2 * 8, "w": 12, "h": 8},
+			Targets:     targets,
+		})
+	}
+	for _, m := range metrics {
+		add(strings.TrimSuffix(m.Help, "."), m.Name, metricTarget(m))
+	}
+	add("Exporter", "Health of the exporter.",
+		grafanaTarget{Expr: upMetric, LegendFormat: "up {{instance}}"},
+		grafanaTarget{Expr: "rate(" + errorsMetric + "[5m])", LegendFormat: "API errors {{instance}}"},
+	)
+	return d
+}
+
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// ruleThresholds are the thresholds of the generated alerts.
+type ruleThresholds struct {
+	Balance       float64
+	ErrorRatio    float64
+	CacheHitDrop  float64
+	ScrapeFailFor string
+}
+
+// generateRules returns recording and alerting rules for the metrics of the
+// exporter.
+func generateRules(e *Exporter, t ruleThresholds) (*ruleGroups, error) {
+	if _, err := model.ParseDuration(t.ScrapeFailFor); err != nil {
+		return nil, fmt.Errorf("invalid scrape failure duration: %v", err)
+	}
+	names := map[string]string{}
+	for key, d := range map[string]*prometheus.Desc{
+		metricRequestsServer:     e.pullZoneMetrics[metricRequestsServer],
+		metricPullRequestsPulled: e.pullZoneMetrics[metricPullRequestsPulled],
+		metricErr5xx:             e.pullZoneMetrics[metricErr5xx],
+		metricBalance:            e.accountMetrics[metricBalance],
+	} {
+		m, err := describeMetric(d)
+		if err != nil {
+			return nil, err
+		}
+		names[key] = m.Name
+	}
+	up, err := collectorName(e.up)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		f         = func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+		requests  = names[metricRequestsServer]
+		pulled    = names[metricPullRequestsPulled]
+		errors5xx = names[metricErr5xx]
+		balance   = names[metricBalance]
+	)
+	return &ruleGroups{Groups: []ruleGroup{
+		{
+			Name: "bunnycdn.rules",
+			Rules: []rule{
+				{
+					Record: "bunnycdn:request_5xx_ratio",
+					Expr:   `sum by (account, pull_zone) (` + errors5xx + `{code="5xx"}) / sum by (account, pull_zone) (` + requests + `)`,
+				},
+				{
+					Record: "bunnycdn:cache_hit_ratio",
+					Expr:   `1 - sum by (account, pull_zone) (` + pulled + `) / sum by (account, pull_zone) (` + requests + `)`,
+				},
+			},
+		},
+		{
+			Name: "bunnycdn.alerts",
+			Rules: []rule{
+				{
+					Alert:       "BunnyCDNBalanceLow",
+					Expr:        balance + " < " + f(t.Balance),
+					For:         "1h",
+					Labels:      map[string]string{"severity": "warning"},
+					Annotations: map[string]string{"summary": "BunnyCDN account balance is {{ $value }}, below " + f(t.Balance) + "."},
+				},
+				{
+					Alert:       "BunnyCDNHigh5xxRatio",
+					Expr:        "bunnycdn:request_5xx_ratio > " + f(t.ErrorRatio),
+					For:         "15m",
+					Labels:      map[string]string{"severity": "critical"},
+					Annotations: map[string]string{"summary": "A ratio of {{ $value }} of the requests of pull zone {{ $labels.pull_zone }} fail with a 5xx error."},
+				},
+				{
+					Alert:       "BunnyCDNCacheHitRatioDrop",
+					Expr:        "bunnycdn:cache_hit_ratio < bunnycdn:cache_hit_ratio offset 1d - " + f(t.CacheHitDrop),
+					For:         "1h",
+					Labels:      map[string]string{"severity": "warning"},
+					Annotations: map[string]string{"summary": "Cache hit ratio of pull zone {{ $labels.pull_zone }} dropped to {{ $value }}."},
+				},
+				{
+					Alert:       "BunnyCDNScrapeFailing",
+					Expr:        up + " == 0",
+					For:         t.ScrapeFailFor,
+					Labels:      map[string]string{"severity": "critical"},
+					Annotations: map[string]string{"summary": "The BunnyCDN exporter {{ $labels.instance }} cannot collect statistics."},
+				},
+			},
+		},
+	}}, nil
+}
+
+// runGenerateDashboard writes the Grafana dashboard of the exporter.
+func runGenerateDashboard(e *Exporter, w io.Writer) error {
+	metrics, err := describeMetrics(e.accountMetrics, e.pullZoneMetrics)
+	if err != nil {
+		return err
+	}
+	up, err := collectorName(e.up)
+	if err != nil {
+		return err
+	}
+	errors, err := collectorName(e.totalErrors)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(generateDashboard(metrics, up, errors))
+}
+
+// runGenerateRules writes the Prometheus rules of the exporter.
+func runGenerateRules(e *Exporter, t ruleThresholds, w io.Writer) error {
+	rules, err := generateRules(e, t)
+	if err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestDescribeMetric(t *testing.T) {
+	m, err := describeMetric(newMetric("request_error_count", `Request "error" by code.`, []string{"pull_zone"}, prometheus.Labels{"code": "5xx"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "bunnycdn_request_error_count", m.Name, "Metric name")
+	assertEqual(t, `Request "error" by code.`, m.Help, "Metric help")
+	assertEqual(t, "code pull_zone", strings.Join(m.Labels, " "), "Metric labels")
+
+	if _, err := describeMetric(prometheus.NewDesc("other", "Other metric.", nil, nil)); err == nil {
+		t.Fatal("Expected an error for a descriptor not made by newMetric")
+	}
+}
+
+func TestGenerateDashboard(t *testing.T) {
+	e, _ := NewExporter("", "", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	var out bytes.Buffer
+	if err := runGenerateDashboard(e, &out); err != nil {
+		t.Fatal(err)
+	}
+	var d grafanaDashboard
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal("Invalid dashboard: ", err)
+	}
+
+	exprs := map[string]string{}
+	for _, p := range d.Panels {
+		exprs[p.Description] = p.Targets[0].Expr
+	}
+	// The error metrics of every code share a panel.
+	assertEqual(t, 9, len(d.Panels), "Panels of the metrics and the exporter")
+	assertEqual(t, `sum by (account, pull_zone, code) (bunnycdn_request_error_count{pull_zone=~"$pull_zone"})`, exprs["bunnycdn_request_error_count"], "Error panel query")
+	assertEqual(t, `topk(10, sum by (location) (bunnycdn_requests_served{pull_zone=~"$pull_zone"}))`, exprs["bunnycdn_requests_served"], "Geo panel query")
+}
+
+func TestGenerateRules(t *testing.T) {
+	e, _ := NewExporter("", "", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	var out bytes.Buffer
+	if err := runGenerateRules(e, ruleThresholds{Balance: 25, ErrorRatio: 0.1, CacheHitDrop: 0.2, ScrapeFailFor: "5m"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var groups ruleGroups
+	if err := yaml.Unmarshal(out.Bytes(), &groups); err != nil {
+		t.Fatal("Invalid rules: ", err)
+	}
+	alerts := map[string]rule{}
+	for _, r := range groups.Groups[1].Rules {
+		alerts[r.Alert] = r
+	}
+	assertEqual(t, "bunnycdn_account_balance < 25", alerts["BunnyCDNBalanceLow"].Expr, "Balance alert")
+	assertEqual(t, "bunnycdn:request_5xx_ratio > 0.1", alerts["BunnyCDNHigh5xxRatio"].Expr, "5xx ratio alert")
+	assertEqual(t, "bunnycdn_up == 0", alerts["BunnyCDNScrapeFailing"].Expr, "Scrape failure alert")
+	assertEqual(t, "5m", alerts["BunnyCDNScrapeFailing"].For, "Scrape failure duration")
+}
+
+func TestGenerateRulesInvalidDuration(t *testing.T) {
+	e, _ := NewExporter("", "", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	if err := runGenerateRules(e, ruleThresholds{ScrapeFailFor: "5 minutes"}, ioutil.Discard); err == nil {
+		t.Fatal("Expected an error for an invalid scrape failure duration")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	geoAggregateLocation = "location"
+	geoAggregateCountry  = "country"
+	geoAggregateRegion   = "region"
+
+	// geoOther is the region and location used for the series that all
+	// locations outside of the top N are summed into.
+	geoOther = "other"
+)
+
+// usStates holds the state codes BunnyCDN uses as the suffix of its North
+// American locations (e.g. "Los Angeles, CA"), so that they can be folded
+// into a single country when aggregating.
+var usStates = map[string]bool{
+	"AL": true, "AK": true, "AZ": true, "AR": true, "CA": true, "CO": true, "CT": true,
+	"DE": true, "DC": true, "FL": true, "GA": true, "HI": true, "ID": true, "IL": true,
+	"IN": true, "IA": true, "KS": true, "KY": true, "LA": true, "ME": true, "MD": true,
+	"MA": true, "MI": true, "MN": true, "MS": true, "MO": true, "MT": true, "NE": true,
+	"NV": true, "NH": true, "NJ": true, "NM": true, "NY": true, "NC": true, "ND": true,
+	"OH": true, "OK": true, "OR": true, "PA": true, "RI": true, "SC": true, "SD": true,
+	"TN": true, "TX": true, "UT": true, "VT": true, "VA": true, "WA": true, "WV": true,
+	"WI": true, "WY": true,
+}
+
+// geoLimits bounds the number of series exported for the geo traffic
+// distribution of a single pull zone.
+type geoLimits struct {
+	// Aggregate is one of geoAggregateLocation, geoAggregateCountry or
+	// geoAggregateRegion.
+	Aggregate string
+	// TopLocations is the number of locations kept per pull zone, the rest
+	// being summed into the "other" location. Zero disables the limit.
+	TopLocations int
+}
+
+// country returns the country of a BunnyCDN location. Locations are named
+// "City, CC", except in North America where the suffix is a state code.
+func (l bunnyLocation) country() string {
+	i := strings.LastIndex(l.Location, ",")
+	if i < 0 {
+		return l.Location
+	}
+	c := strings.TrimSpace(l.Location[i+1:])
+	if l.Region == "NA" && usStates[c] {
+		return "US"
+	}
+	return c
+}
+
+// apply aggregates and truncates locations according to the limits. It
+// returns the resulting locations, sorted by descending number of requests,
+// along with the number of source series that were folded into another one.
+func (g geoLimits) apply(locations []bunnyLocation) ([]bunnyLocation, int) {
+	var result []bunnyLocation
+	switch g.Aggregate {
+	case geoAggregateCountry, geoAggregateRegion:
+		index := map[bunnyLocation]int{}
+		for _, loc := range locations {
+			key := bunnyLocation{Region: loc.Region}
+			if g.Aggregate == geoAggregateCountry {
+				key.Location = loc.country()
+			}
+			i, ok := index[key]
+			if !ok {
+				i = len(result)
+				index[key] = i
+				result = append(result, key)
+			}
+			result[i].Requests += loc.Requests
+		}
+	default:
+		result = append(result, locations...)
+	}
+
+	folded := len(locations) - len(result)
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		if result[i].Region != result[j].Region {
+			return result[i].Region < result[j].Region
+		}
+		return result[i].Location < result[j].Location
+	})
+
+	if g.TopLocations > 0 && len(result) > g.TopLocations {
+		other := bunnyLocation{Region: geoOther, Location: geoOther}
+		for _, loc := range result[g.TopLocations:] {
+			other.Requests += loc.Requests
+		}
+		folded += len(result) - g.TopLocations
+		result = append(result[:g.TopLocations], other)
+	}
+
+	return result, folded
+}
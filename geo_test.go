@@ -0,0 +1,81 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testLocations = []bunnyLocation{
+	{Region: "EU", Location: "London, UK", Requests: 600},
+	{Region: "NA", Location: "Los Angeles, CA", Requests: 500},
+	{Region: "NA", Location: "Atlanta, GA", Requests: 300},
+	{Region: "EU", Location: "Amsterdam, NL", Requests: 200},
+	{Region: "EU", Location: "Frankfurt, DE", Requests: 100},
+}
+
+func TestGeoTopLocations(t *testing.T) {
+	locs, folded := geoLimits{Aggregate: geoAggregateLocation, TopLocations: 2}.apply(testLocations)
+
+	if len(locs) != 3 {
+		t.Fatal("Number of locations: expected: 3, got: ", len(locs))
+	}
+	assertEqual(t, "London, UK", locs[0].Location, "Top location")
+	assertEqual(t, "Los Angeles, CA", locs[1].Location, "Second location")
+	assertEqual(t, geoOther, locs[2].Location, "Remainder location")
+	assertEqual(t, float64(600), locs[2].Requests, "Requests summed into remainder")
+	assertEqual(t, 3, folded, "Number of folded series")
+}
+
+func TestGeoAggregateCountry(t *testing.T) {
+	locs, folded := geoLimits{Aggregate: geoAggregateCountry}.apply(testLocations)
+
+	if len(locs) != 4 {
+		t.Fatal("Number of countries: expected: 4, got: ", len(locs))
+	}
+	assertEqual(t, "US", locs[0].Location, "Country with most requests")
+	assertEqual(t, float64(800), locs[0].Requests, "Requests summed for country")
+	assertEqual(t, 1, folded, "Number of folded series")
+}
+
+func TestGeoAggregateRegion(t *testing.T) {
+	locs, folded := geoLimits{Aggregate: geoAggregateRegion, TopLocations: 1}.apply(testLocations)
+
+	if len(locs) != 2 {
+		t.Fatal("Number of regions: expected: 2, got: ", len(locs))
+	}
+	assertEqual(t, "EU", locs[0].Region, "Region with most requests")
+	assertEqual(t, "", locs[0].Location, "Location of aggregated region")
+	assertEqual(t, float64(900), locs[0].Requests, "Requests summed for region")
+	assertEqual(t, float64(800), locs[1].Requests, "Requests summed into remainder")
+	assertEqual(t, 4, folded, "Number of folded series")
+}
+
+func TestGeoFoldedGauge(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "zone"}]`), []byte(`{
+		"GeoTrafficDistribution": {"EU: London, GB": 10, "NA: Chicago, IL": 20, "NA: Atlanta, GA": 5}
+	}`))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{Aggregate: geoAggregateLocation, TopLocations: 1})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+
+	reg.Gather()
+	reg.Gather()
+	assertEqual(t, float64(2), testutil.ToFloat64(exporter.geoFolded), "Series folded by the last scrape")
+}
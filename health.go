@@ -0,0 +1,132 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// apiHealth is the outcome of the last calls listing the pull zones, which
+// tells whether the BunnyCDN API is reachable with the API key. It has its
+// own mutex, so that checks do not wait for scrapes.
+type apiHealth struct {
+	mtx sync.Mutex
+	// fetch calls the API of the current configuration.
+	fetch func(path string) (io.ReadCloser, error)
+	// listing is the number of calls in progress.
+	listing     int
+	lastCall    time.Time
+	lastSuccess time.Time
+	lastErr     error
+}
+
+func (h *apiHealth) setFetch(fetch func(path string) (io.ReadCloser, error)) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.fetch = fetch
+}
+
+// begin records the start of a call.
+func (h *apiHealth) begin() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.listing++
+}
+
+// record records the outcome of a call.
+func (h *apiHealth) record(now time.Time, err error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.listing--
+	h.lastCall = now
+	h.lastErr = err
+	if err == nil {
+		h.lastSuccess = now
+	}
+}
+
+// ready tells whether the pull zones were listed within window, and if not
+// why. An API key rejected by the last call is never ready.
+func (h *apiHealth) ready(now time.Time, window time.Duration) (bool, string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if err, ok := h.lastErr.(*httpStatusError); ok && (err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden) {
+		return false, fmt.Sprintf("BunnyCDN API key rejected: %v", err)
+	}
+	if h.lastSuccess.IsZero() {
+		return false, fmt.Sprintf("BunnyCDN API not reachable yet: %v", h.lastErr)
+	}
+	if now.Sub(h.lastSuccess) > window {
+		return false, fmt.Sprintf("BunnyCDN API not reached successfully since %s: %v", h.lastSuccess.Format(time.RFC3339), h.lastErr)
+	}
+	return true, "BunnyCDN API reachable"
+}
+
+// check returns the API to list the pull zones with if they were not listed
+// within window and no call is in progress, which is then begun.
+func (h *apiHealth) check(now time.Time, window time.Duration) (func(path string) (io.ReadCloser, error), bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.listing > 0 || now.Sub(h.lastCall) <= window {
+		return nil, false
+	}
+	h.listing++
+	return h.fetch, true
+}
+
+// listPullZones lists the pull zones, recording the outcome.
+func (e *Exporter) listPullZones() ([]bunnyPullZone, error) {
+	e.health.begin()
+	return e.recordListPullZones(e.fetch)
+}
+
+func (e *Exporter) recordListPullZones(fetch func(path string) (io.ReadCloser, error)) ([]bunnyPullZone, error) {
+	pullZones, err := listPullZones(fetch)
+	e.totalAPICalls.Inc()
+	e.health.record(time.Now(), err)
+	return pullZones, err
+}
+
+// serveHealthy tells that the process is alive.
+func serveHealthy(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("Healthy\n"))
+}
+
+// ready lists the pull zones if nothing did within window, and tells whether
+// the API was reached. While a scrape lists them, the previous outcome is
+// used rather than waiting for it.
+func (e *Exporter) ready(window time.Duration) (bool, string) {
+	if fetch, ok := e.health.check(time.Now(), window); ok {
+		e.recordListPullZones(fetch)
+	}
+	return e.health.ready(time.Now(), window)
+}
+
+// serveReady tells whether the BunnyCDN API was reached with the API key
+// within window. If no scrape did in that time, the pull zones are listed,
+// which is a single API call rather than a full scrape.
+func (e *Exporter) serveReady(window time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, reason := e.ready(window)
+		if !ok {
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(reason + "\n"))
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	var status, calls int32 = http.StatusOK, 0
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		w.Write([]byte(`[]`))
+	}))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+
+	ready := func(window time.Duration) (int, string) {
+		rec := httptest.NewRecorder()
+		exporter.serveReady(window)(rec, httptest.NewRequest("GET", "/-/ready", nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, _ := ready(time.Minute)
+	assertEqual(t, http.StatusOK, code, "Ready with a working API")
+	assertEqual(t, int32(1), atomic.LoadInt32(&calls), "API calls of the first check")
+	ready(time.Minute)
+	assertEqual(t, int32(1), atomic.LoadInt32(&calls), "API calls within the window")
+
+	atomic.StoreInt32(&status, http.StatusUnauthorized)
+	code, body := ready(0)
+	assertEqual(t, http.StatusServiceUnavailable, code, "Ready with a revoked API key")
+	assertEqual(t, true, strings.Contains(body, "API key rejected"), "Reason of a revoked API key")
+	code, _ = ready(time.Minute)
+	assertEqual(t, http.StatusServiceUnavailable, code, "Ready within the window of a revoked API key")
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	code, body = ready(0)
+	assertEqual(t, http.StatusServiceUnavailable, code, "Ready with a failing API")
+	assertEqual(t, true, strings.Contains(body, "HTTP status 500"), "Reason of a failing API")
+	code, _ = ready(time.Minute)
+	assertEqual(t, http.StatusOK, code, "Ready with a failing API after a recent success")
+}
+
+func TestHealthy(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveHealthy(rec, httptest.NewRequest("GET", "/-/healthy", nil))
+	assertEqual(t, http.StatusOK, rec.Code, "Healthy")
+}
+
+func TestReadyDuringScrape(t *testing.T) {
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+
+	// A scrape in progress holds the mutex of the exporter.
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	done := make(chan bool)
+	go func() {
+		ok, _ := exporter.ready(time.Minute)
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		assertEqual(t, true, ok, "Ready during a scrape")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Readiness check waited for the scrape")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	// Line protocol cannot hold newlines at all, so they become spaces.
+	influxTagEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+)
+
+// influxLines converts gathered metric families to InfluxDB line protocol,
+// with one measurement per family and the labels as tags. Counters, gauges
+// and untyped metrics have a value field; histograms and summaries have
+// count and sum fields, and a field per bucket or quantile. Points without a
+// timestamp get now.
+func influxLines(mfs []*dto.MetricFamily, now time.Time) []string {
+	var lines []string
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var b strings.Builder
+			b.WriteString(influxMeasurementEscaper.Replace(mf.GetName()))
+			for _, l := range m.GetLabel() {
+				if l.GetValue() == "" {
+					continue
+				}
+				b.WriteString("," + influxTagEscaper.Replace(l.GetName()) + "=" + influxTagEscaper.Replace(l.GetValue()))
+			}
+
+			var fields []string
+			field := func(name string, value float64) {
+				fields = append(fields, influxTagEscaper.Replace(name)+"="+strconv.FormatFloat(value, 'f', -1, 64))
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				field("value", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				field("value", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				field("value", m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				field("count", float64(h.GetSampleCount()))
+				field("sum", h.GetSampleSum())
+				for _, bucket := range h.GetBucket() {
+					field(formatFloat(bucket.GetUpperBound()), float64(bucket.GetCumulativeCount()))
+				}
+				field("+Inf", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				field("count", float64(s.GetSampleCount()))
+				field("sum", s.GetSampleSum())
+				for _, q := range s.GetQuantile() {
+					field(formatFloat(q.GetQuantile()), q.GetValue())
+				}
+			}
+
+			ts := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			b.WriteString(" " + strings.Join(fields, ",") + " " + strconv.FormatInt(ts, 10))
+			lines = append(lines, b.String())
+		}
+	}
+	return lines
+}
+
+// influxConfig configures writing metrics to InfluxDB.
+type influxConfig struct {
+	// URL is the base URL of an InfluxDB 2 server; nothing is sent if empty.
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+
+	Timeout    time.Duration
+	BatchSize  int
+	MaxRetries int
+	MinBackoff time.Duration
+}
+
+// influxWriter periodically gathers metrics and writes them to InfluxDB, to a
+// file, or both.
+type influxWriter struct {
+	cfg      influxConfig
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	file     io.Writer
+
+	pointsSent, pointsFailed prometheus.Counter
+}
+
+func newInfluxWriter(cfg influxConfig, gatherer prometheus.Gatherer, file io.Writer) *influxWriter {
+	return &influxWriter{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		gatherer: gatherer,
+		file:     file,
+		pointsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_influx_points_sent_total",
+			Help:      "Number of points successfully written to InfluxDB.",
+		}),
+		pointsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_influx_points_failed_total",
+			Help:      "Number of points that could not be written to InfluxDB.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (w *influxWriter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.pointsSent.Desc()
+	ch <- w.pointsFailed.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (w *influxWriter) Collect(ch chan<- prometheus.Metric) {
+	ch <- w.pointsSent
+	ch <- w.pointsFailed
+}
+
+func (w *influxWriter) write(ctx context.Context) {
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		log.Errorf("Error gathering metrics for InfluxDB: %v", err)
+	}
+	lines := influxLines(mfs, time.Now())
+	if len(lines) == 0 {
+		return
+	}
+
+	if w.file != nil {
+		if _, err := io.WriteString(w.file, strings.Join(lines, "\n")+"\n"); err != nil {
+			log.Errorf("Unable to write InfluxDB lines to file: %v", err)
+		}
+	}
+	if w.cfg.URL == "" {
+		return
+	}
+	for len(lines) > 0 {
+		n := w.cfg.BatchSize
+		if n <= 0 || n > len(lines) {
+			n = len(lines)
+		}
+		if err := w.send(ctx, lines[:n]); err != nil {
+			log.Errorf("Unable to write %d points to InfluxDB: %v", n, err)
+			w.pointsFailed.Add(float64(n))
+		} else {
+			w.pointsSent.Add(float64(n))
+		}
+		lines = lines[n:]
+	}
+}
+
+// send writes a batch of lines, retrying on network errors, throttling and
+// server errors until ctx is done.
+func (w *influxWriter) send(ctx context.Context, lines []string) error {
+	u, err := url.Parse(w.cfg.URL)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+	u.RawQuery = url.Values{
+		"org":       {w.cfg.Org},
+		"bucket":    {w.cfg.Bucket},
+		"precision": {"ms"},
+	}.Encode()
+	body := []byte(strings.Join(lines, "\n"))
+
+	backoff := w.cfg.MinBackoff
+	for try := 0; ; try++ {
+		recoverable, err := w.post(u.String(), body)
+		if err == nil || !recoverable || try >= w.cfg.MaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (w *influxWriter) post(uri string, body []byte) (recoverable bool, err error) {
+	req, err := http.NewRequest("POST", uri, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "bunnycdn_exporter")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// run writes at every interval until ctx is done. A write in progress is
+// completed, without retries once ctx is done.
+func (w *influxWriter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.write(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInfluxLines(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "my zone"}]`), []byte(`{
+		"BandwidthUsedChart": {"2019-05-02T00:00:00": 100},
+		"GeoTrafficDistribution": {"EU: London, GB": 10}
+	}`))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, nil, pullZoneMetrics, time.Second, geoLimits{})
+	exporter.chartTimestamps = true
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+	mfs, _ := reg.Gather()
+
+	lines := strings.Join(influxLines(mfs, time.Unix(1, 0)), "\n")
+	for _, expected := range []string{
+		`bunnycdn_bandwidth_used_bytes_total,pull_zone=my\ zone value=100 1556755200000`,
+		`bunnycdn_requests_served,location=London\,\ GB,pull_zone=my\ zone,region=EU value=10 1000`,
+	} {
+		if !strings.Contains(lines, expected) {
+			t.Errorf("Line %q not found in:\n%s", expected, lines)
+		}
+	}
+}
+
+func TestInfluxWrite(t *testing.T) {
+	var (
+		requests     int
+		query, token string
+		body         []byte
+	)
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		query, token = r.URL.RawQuery, r.Header.Get("Authorization")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cost"})
+	g.Set(2.5)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(g)
+
+	var file bytes.Buffer
+	w := newInfluxWriter(influxConfig{
+		URL:        influx.URL,
+		Org:        "org",
+		Bucket:     "cdn",
+		Token:      "secret",
+		Timeout:    time.Second,
+		MaxRetries: 1,
+		MinBackoff: time.Millisecond,
+	}, reg, &file)
+	w.write(context.Background())
+
+	assertEqual(t, 2, requests, "Requests including the retried one")
+	assertEqual(t, "bucket=cdn&org=org&precision=ms", query, "Write query")
+	assertEqual(t, "Token secret", token, "Authorization header")
+	assertEqual(t, string(body)+"\n", file.String(), "Same lines in file and request")
+	assertEqual(t, 1.0, testutil.ToFloat64(w.pointsSent), "Points sent")
+}
+
+func TestInfluxEscaping(t *testing.T) {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cost"}, []string{"pull_zone"})
+	g.WithLabelValues("a\\b\nc").Set(1)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(g)
+	mfs, _ := reg.Gather()
+
+	lines := influxLines(mfs, time.Unix(1, 0))
+	assertEqual(t, 1, len(lines), "Number of lines")
+	assertEqual(t, `cost,pull_zone=a\\b\ c value=1 1000`, lines[0], "Escaped line")
+}
+
+func TestInfluxWriteEmpty(t *testing.T) {
+	var file bytes.Buffer
+	w := newInfluxWriter(influxConfig{}, prometheus.NewRegistry(), &file)
+	w.write(context.Background())
+	assertEqual(t, "", file.String(), "File after an empty collection")
+}
+
+func TestInfluxRunCancelled(t *testing.T) {
+	requests := 0
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "try again", http.StatusInternalServerError)
+	}))
+	defer influx.Close()
+
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cost"})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(g)
+	w := newInfluxWriter(influxConfig{URL: influx.URL, Timeout: time.Second, MaxRetries: 3, MinBackoff: time.Hour}, reg, nil)
+
+	// The write made before noticing the shutdown is not retried.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx, time.Hour)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write still retrying after the shutdown")
+	}
+	assertEqual(t, 1, requests, "Requests before returning")
+	assertEqual(t, 1.0, testutil.ToFloat64(w.pointsFailed), "Failed points")
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func getOK(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	// A stale socket is replaced.
+	stale, err := net.Listen("unix", testSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listen("unix://"+testSocket, 0600)
+	if err != nil {
+		t.Fatal("Unexpected error listening: ", err)
+	}
+	defer l.Close()
+	fi, err := os.Stat(testSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, os.FileMode(0600), fi.Mode().Perm(), "Socket permissions")
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", testSocket)
+		},
+	}}
+	assertEqual(t, "ok", getOK(t, client, "http://unix/metrics"), "Response over the Unix socket")
+}
+
+func TestRestrictUmask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "umask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	restore := restrictUmask()
+	f, err := os.OpenFile(dir+"/file", os.O_CREATE|os.O_WRONLY, 0666)
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fi, err := os.Stat(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, os.FileMode(0600), fi.Mode().Perm(), "Permissions of a file created with a restricted umask")
+}
+
+func TestListenSocketActivation(t *testing.T) {
+	activated, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer activated.Close()
+	f, err := activated.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The descriptor is closed by the activation, so it must not be owned by
+	// an os.File.
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(start int) { listenFDsStart = start }(listenFDsStart)
+	listenFDsStart = fd
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+
+	l, err := listen("unix://"+testSocket, 0600)
+	if err != nil {
+		t.Fatal("Unexpected error listening: ", err)
+	}
+	defer l.Close()
+	assertEqual(t, activated.Addr().String(), l.Addr().String(), "Address of the activated socket")
+	assertEqual(t, "", os.Getenv("LISTEN_FDS"), "LISTEN_FDS after activation")
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("activated"))
+	}))
+	assertEqual(t, "activated", getOK(t, http.DefaultClient, "http://"+l.Addr().String()), "Response over the activated socket")
+}
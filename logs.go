@@ -0,0 +1,335 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// logDateFormat is the date format used by the BunnyCDN logging API paths.
+const logDateFormat = "01-02-06"
+
+// logCacheStatuses are the cache statuses exported as they are. Any other is
+// exported as "OTHER", as log lines received over syslog are not trusted.
+var logCacheStatuses = map[string]bool{
+	"HIT":     true,
+	"MISS":    true,
+	"EXPIRED": true,
+	"STALE":   true,
+	"BYPASS":  true,
+}
+
+// bunnyLogLine is a single access log entry. BunnyCDN writes them pipe
+// delimited, in the following order:
+//
+//	CacheStatus|Status|Timestamp|BytesSent|PullZoneID|RemoteIP|Referer|URL|EdgeLocation|UserAgent|RequestID|Country
+//
+// The last two fields are missing from older log files.
+type bunnyLogLine struct {
+	CacheStatus  string
+	Status       int
+	Timestamp    time.Time
+	BytesSent    float64
+	PullZoneID   int64
+	RemoteIP     string
+	Referer      string
+	URL          string
+	EdgeLocation string
+	UserAgent    string
+	RequestID    string
+	Country      string
+}
+
+func parseLogLine(line string) (bunnyLogLine, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 10 {
+		return bunnyLogLine{}, fmt.Errorf("expected at least 10 fields, got %d", len(fields))
+	}
+
+	status, err := strconv.Atoi(fields[1])
+	if err != nil || status < 100 || status > 599 {
+		return bunnyLogLine{}, fmt.Errorf("invalid status %q", fields[1])
+	}
+	ms, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return bunnyLogLine{}, fmt.Errorf("invalid timestamp %q: %v", fields[2], err)
+	}
+	bytesSent, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return bunnyLogLine{}, fmt.Errorf("invalid bytes sent %q: %v", fields[3], err)
+	}
+	pullZoneID, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return bunnyLogLine{}, fmt.Errorf("invalid pull zone %q: %v", fields[4], err)
+	}
+
+	cacheStatus := fields[0]
+	if !logCacheStatuses[cacheStatus] {
+		cacheStatus = "OTHER"
+	}
+	l := bunnyLogLine{
+		CacheStatus:  cacheStatus,
+		Status:       status,
+		Timestamp:    time.Unix(0, ms*int64(time.Millisecond)).UTC(),
+		BytesSent:    bytesSent,
+		PullZoneID:   pullZoneID,
+		RemoteIP:     validUTF8(fields[5]),
+		Referer:      validUTF8(fields[6]),
+		URL:          validUTF8(fields[7]),
+		EdgeLocation: validUTF8(fields[8]),
+		UserAgent:    validUTF8(fields[9]),
+	}
+	if len(fields) > 10 {
+		l.RequestID = validUTF8(fields[10])
+	}
+	if len(fields) > 11 {
+		l.Country = validUTF8(fields[11])
+	}
+	return l, nil
+}
+
+// validUTF8 replaces the invalid UTF-8 sequences of s, as the fields of log
+// lines may end up in label values.
+func validUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		// Invalid bytes are decoded as utf8.RuneError.
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// logMetrics are the metrics derived from individual access log lines,
+// whichever way they reached the exporter.
+type logMetrics struct {
+	requests  *prometheus.CounterVec
+	bytesSent *prometheus.HistogramVec
+	invalid   *prometheus.CounterVec
+
+	// hitters, if set, also tracks the most requested values.
+	hitters *heavyHitters
+}
+
+func newLogMetrics() *logMetrics {
+	return &logMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_requests_total",
+			Help:      "Number of requests found in the access logs.",
+		}, []string{"pull_zone", "status", "cache_status"}),
+		bytesSent: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "log_bytes_sent",
+			Help:      "Bytes sent per request found in the access logs.",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 10),
+		}, []string{"pull_zone"}),
+		invalid: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_lines_invalid_total",
+			Help:      "Number of access log lines that could not be parsed.",
+		}, []string{"pull_zone"}),
+	}
+}
+
+func (m *logMetrics) observe(pullZone string, l bunnyLogLine) {
+	m.requests.WithLabelValues(pullZone, strconv.Itoa(l.Status), l.CacheStatus).Inc()
+	m.bytesSent.WithLabelValues(pullZone).Observe(l.BytesSent)
+	if m.hitters != nil {
+		m.hitters.observe(pullZone, l)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *logMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.bytesSent.Describe(ch)
+	m.invalid.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *logMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.bytesSent.Collect(ch)
+	m.invalid.Collect(ch)
+}
+
+// logCursor remembers how far the log file of a pull zone has been read.
+type logCursor struct {
+	date   time.Time
+	offset int64
+	// skip moves the cursor to the end of the file without processing the
+	// lines, which were logged before the exporter started.
+	skip bool
+}
+
+// logCollector periodically downloads the access logs of every pull zone
+// that has logging enabled and feeds the lines it has not seen yet to
+// logMetrics.
+type logCollector struct {
+	pullZones func() ([]bunnyPullZone, error)
+	fetchLogs func(path string) (io.ReadCloser, error)
+	metrics   *logMetrics
+	now       func() time.Time
+
+	cursors map[int64]*logCursor
+	// started is set after the first run. Pull zones found later have their
+	// logs read from the start of the day.
+	started     bool
+	totalErrors prometheus.Counter
+}
+
+func newLogCollector(pullZones func() ([]bunnyPullZone, error), fetchLogs func(path string) (io.ReadCloser, error), metrics *logMetrics) *logCollector {
+	return &logCollector{
+		pullZones: pullZones,
+		fetchLogs: fetchLogs,
+		metrics:   metrics,
+		now:       time.Now,
+		cursors:   map[int64]*logCursor{},
+		totalErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_fetch_errors_total",
+			Help:      "Number of errors while downloading access logs.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *logCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.totalErrors.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (c *logCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.totalErrors
+}
+
+func (c *logCollector) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.collect()
+		<-ticker.C
+	}
+}
+
+func (c *logCollector) collect() {
+	pullZones, err := c.pullZones()
+	if err != nil {
+		log.Errorf("Unable to list pull zones for logs: %v", err)
+		c.totalErrors.Inc()
+		return
+	}
+
+	y, m, d := c.now().UTC().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	logged := map[int64]bool{}
+	for _, pz := range pullZones {
+		if !pz.EnableLogging {
+			continue
+		}
+		logged[pz.ID] = true
+		cur, ok := c.cursors[pz.ID]
+		if !ok {
+			cur = &logCursor{date: today, skip: !c.started}
+			c.cursors[pz.ID] = cur
+		}
+		// Finish the files of previous days before moving on, so that lines
+		// written after the last run of the day are not lost.
+		for {
+			if err := c.read(pz, cur); err != nil {
+				log.Errorf("Unable to read logs of pull zone %s: %v", pz.Name, err)
+				c.totalErrors.Inc()
+				break
+			}
+			cur.skip = false
+			if !cur.date.Before(today) {
+				break
+			}
+			cur.date = cur.date.AddDate(0, 0, 1)
+			cur.offset = 0
+		}
+	}
+	// Deleted pull zones and those no longer logging are forgotten.
+	for id := range c.cursors {
+		if !logged[id] {
+			delete(c.cursors, id)
+		}
+	}
+	c.started = true
+}
+
+// read processes the complete lines of a log file past the cursor, and
+// advances the cursor accordingly.
+func (c *logCollector) read(pz bunnyPullZone, cur *logCursor) error {
+	body, err := c.fetchLogs(fmt.Sprintf("/%s/%d.log", cur.date.Format(logDateFormat), pz.ID))
+	if err != nil {
+		if se, ok := err.(*httpStatusError); ok && se.StatusCode == http.StatusNotFound {
+			// Nothing was logged yet.
+			return nil
+		}
+		return err
+	}
+	defer body.Close()
+
+	if _, err := io.CopyN(ioutil.Discard, body, cur.offset); err != nil {
+		if err == io.EOF {
+			log.Warnf("Log file of pull zone %s is shorter than already read, skipping", pz.Name)
+			return nil
+		}
+		return err
+	}
+
+	r := bufio.NewReader(body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// An incomplete last line is left for the next run.
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		cur.offset += int64(len(line))
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		if cur.skip {
+			continue
+		}
+		l, err := parseLogLine(line)
+		if err != nil {
+			log.Debugf("Invalid log line for pull zone %s: %v", pz.Name, err)
+			c.metrics.invalid.WithLabelValues(pz.Name).Inc()
+			continue
+		}
+		c.metrics.observe(pz.Name, l)
+	}
+}
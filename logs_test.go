@@ -0,0 +1,128 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	testLogHit  = "HIT|200|1556755200000|1024|12345|1.2.3.4|-|https://pullzonename.b-cdn.net/a.js|DE|Mozilla/5.0|d6b7c1|DE\n"
+	testLogMiss = "MISS|404|1556755201000|512|12345|1.2.3.4|-|https://pullzonename.b-cdn.net/b.js|DE|Mozilla/5.0|d6b7c2|DE\n"
+)
+
+func TestParseLogLine(t *testing.T) {
+	l, err := parseLogLine(testLogMiss[:len(testLogMiss)-1])
+	if err != nil {
+		t.Fatal("Unexpected error parsing log line: ", err)
+	}
+	assertEqual(t, "MISS", l.CacheStatus, "Cache status")
+	assertEqual(t, 404, l.Status, "Status code")
+	assertEqual(t, float64(512), l.BytesSent, "Bytes sent")
+	assertEqual(t, int64(12345), l.PullZoneID, "Pull zone ID")
+	assertEqual(t, "https://pullzonename.b-cdn.net/b.js", l.URL, "URL")
+	assertEqual(t, "DE", l.Country, "Country")
+	assertEqual(t, int64(1556755201), l.Timestamp.Unix(), "Timestamp")
+
+	if _, err := parseLogLine("HIT|200|not a timestamp"); err == nil {
+		t.Fatal("Expected an error parsing a truncated log line")
+	}
+}
+
+func TestLogCollectorCursor(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		logFile string
+	)
+	pullZones := []byte(`[{"Id": 12345,"Name": "pullzonename","EnableLogging": true},{"Id": 34567,"Name": "pullzonename2","EnableLogging": false}]`)
+	h := newBunny(pullZones, nil)
+	defer h.Close()
+	logs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/05-02-19/12345.log" {
+			http.NotFound(w, r)
+			return
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		w.Write([]byte(logFile))
+	}))
+	defer logs.Close()
+
+	metrics := newLogMetrics()
+	source := newTestPullZoneSource(h.URL, logs.URL)
+	c := newLogCollector(source.list, source.logs, metrics)
+	c.now = func() time.Time { return time.Date(2019, 5, 2, 12, 0, 0, 0, time.UTC) }
+
+	// The first line was logged before the exporter started and must not be
+	// counted. The second line is still being written and must only be
+	// counted once it is complete.
+	mtx.Lock()
+	logFile = testLogHit + testLogMiss[:20]
+	mtx.Unlock()
+	c.collect()
+	assertEqual(t, float64(0), testutil.ToFloat64(metrics.requests.WithLabelValues("pullzonename", "200", "HIT")), "Hits after first run")
+	assertEqual(t, float64(0), testutil.ToFloat64(metrics.requests.WithLabelValues("pullzonename", "404", "MISS")), "Misses after first run")
+
+	mtx.Lock()
+	logFile = testLogHit + testLogMiss + "garbage\n"
+	mtx.Unlock()
+	c.collect()
+	c.collect()
+	assertEqual(t, float64(0), testutil.ToFloat64(metrics.requests.WithLabelValues("pullzonename", "200", "HIT")), "Hits after later runs")
+	assertEqual(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues("pullzonename", "404", "MISS")), "Misses after later runs")
+	assertEqual(t, float64(1), testutil.ToFloat64(metrics.invalid.WithLabelValues("pullzonename")), "Invalid lines")
+	assertEqual(t, float64(0), testutil.ToFloat64(c.totalErrors), "Fetch errors")
+	assertEqual(t, 1, len(c.cursors), "Cursors of pull zones logging")
+}
+
+func TestLogCollectorPullZoneChanges(t *testing.T) {
+	var (
+		mtx       sync.Mutex
+		pullZones = `[{"Id": 12345,"Name": "pullzonename","EnableLogging": true}]`
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		w.Write([]byte(pullZones))
+	}))
+	defer api.Close()
+	logs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testLogHit))
+	}))
+	defer logs.Close()
+
+	metrics := newLogMetrics()
+	source := newTestPullZoneSource(api.URL, logs.URL)
+	c := newLogCollector(source.list, source.logs, metrics)
+	c.now = func() time.Time { return time.Date(2019, 5, 2, 12, 0, 0, 0, time.UTC) }
+	c.collect()
+
+	// A pull zone created after the start has its logs read from the start
+	// of the day, and a deleted one is forgotten.
+	mtx.Lock()
+	pullZones = `[{"Id": 34567,"Name": "pullzonename2","EnableLogging": true}]`
+	mtx.Unlock()
+	c.collect()
+	assertEqual(t, float64(0), testutil.ToFloat64(metrics.requests.WithLabelValues("pullzonename", "200", "HIT")), "Hits logged before the start")
+	assertEqual(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues("pullzonename2", "200", "HIT")), "Hits of a new pull zone")
+	if _, ok := c.cursors[12345]; ok {
+		t.Error("Cursor of a deleted pull zone should be dropped")
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	notifyFormatSlack = "slack"
+	notifyFormatJSON  = "json"
+
+	// notifyBudgetDefault is the pull zone name of the budget of zones
+	// without their own.
+	notifyBudgetDefault = "*"
+)
+
+// notifyRules are the conditions notified on. Zero values disable a rule.
+type notifyRules struct {
+	BalanceBelow float64
+	// DepletionDays notifies when the balance would run out within that many
+	// days at the rate it decreased over DepletionWindow.
+	DepletionDays   float64
+	DepletionWindow time.Duration
+	// ZoneBudgets are the maximum monthly charges by pull zone name.
+	ZoneBudgets map[string]float64
+}
+
+// parseZoneBudgets parses the budgets given as pull zone name to amount.
+func parseZoneBudgets(budgets map[string]string) (map[string]float64, error) {
+	parsed := make(map[string]float64, len(budgets))
+	for zone, amount := range budgets {
+		v, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid budget of pull zone %s: %v", zone, err)
+		}
+		parsed[zone] = v
+	}
+	return parsed, nil
+}
+
+func (r notifyRules) budget(pullZone string) float64 {
+	if b, ok := r.ZoneBudgets[pullZone]; ok {
+		return b
+	}
+	return r.ZoneBudgets[notifyBudgetDefault]
+}
+
+// notification is a rule that currently holds.
+type notification struct {
+	Alert     string
+	PullZone  string
+	Summary   string
+	Value     float64
+	Threshold float64
+	Since     time.Time
+	// LastSent is zero until the notification was successfully sent.
+	LastSent time.Time
+}
+
+func (n *notification) key() string {
+	return n.Alert + "/" + n.PullZone
+}
+
+// notifier evaluates rules on the snapshot of every scrape, and posts webhooks
+// when they start or stop holding. Notifications that keep holding are sent
+// again every resend interval.
+type notifier struct {
+	// account is the name of the account, empty if there is only one.
+	account string
+	url     string
+	format  string
+	client  *http.Client
+	rules   notifyRules
+	resend  time.Duration
+	queue   chan *snapshot
+
+	mtx      sync.Mutex
+	balances []snapshotPoint
+	active   map[string]*notification
+
+	sent, errors prometheus.Counter
+}
+
+func newNotifier(url, format string, rules notifyRules, resend, timeout time.Duration) *notifier {
+	return &notifier{
+		url:    url,
+		format: format,
+		client: &http.Client{Timeout: timeout},
+		rules:  rules,
+		resend: resend,
+		queue:  make(chan *snapshot, 1),
+		active: map[string]*notification{},
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_notifications_sent_total",
+			Help:      "Number of webhook notifications sent.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_notification_errors_total",
+			Help:      "Number of webhook notifications that could not be sent.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (n *notifier) Describe(ch chan<- *prometheus.Desc) {
+	ch <- n.sent.Desc()
+	ch <- n.errors.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (n *notifier) Collect(ch chan<- prometheus.Metric) {
+	ch <- n.sent
+	ch <- n.errors
+}
+
+// notify queues a snapshot for evaluation without blocking the scrape. If
+// the previous one is still being evaluated, it is skipped.
+func (n *notifier) notify(s *snapshot) {
+	select {
+	case n.queue <- s:
+	default:
+	}
+}
+
+// run evaluates the queued snapshots. If none was queued during an interval,
+// as when no Prometheus server scrapes the exporter, collect is called to
+// take one.
+func (n *notifier) run(interval time.Duration, collect func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	queued := false
+	for {
+		select {
+		case s := <-n.queue:
+			queued = true
+			n.evaluate(s)
+		case <-ticker.C:
+			if !queued {
+				collect()
+			}
+			queued = false
+		}
+	}
+}
+
+// discardCollect runs a collection and drops its metrics, for the snapshot
+// it takes.
+func discardCollect(c prometheus.Collector) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	c.Collect(ch)
+	close(ch)
+	<-done
+}
+
+// depletionDays returns in how many days the balance runs out at the rate it
+// decreased since the oldest balance kept, or false if it did not decrease
+// over at least an hour.
+func (n *notifier) depletionDays() (float64, bool) {
+	if len(n.balances) < 2 {
+		return 0, false
+	}
+	first, last := n.balances[0], n.balances[len(n.balances)-1]
+	span := last.Time.Sub(first.Time)
+	if span < time.Hour || last.Value >= first.Value {
+		return 0, false
+	}
+	perDay := (first.Value - last.Value) / span.Hours() * 24
+	return last.Value / perDay, true
+}
+
+// Alerts of the account rules and of the pull zone rules.
+var (
+	notifyAccountAlerts  = []string{"BalanceLow", "BalanceDepletion"}
+	notifyPullZoneAlerts = []string{"ZoneBudgetExceeded"}
+)
+
+// accountHolding returns the notifications of the account rules holding for
+// a snapshot.
+func (n *notifier) accountHolding(s *snapshot) []*notification {
+	var holding []*notification
+	balance := s.Account.Balance.Value
+
+	n.balances = append(n.balances, snapshotPoint{Time: s.CollectedAt, Value: balance})
+	for len(n.balances) > 0 && s.CollectedAt.Sub(n.balances[0].Time) > n.rules.DepletionWindow {
+		n.balances = n.balances[1:]
+	}
+
+	if n.rules.BalanceBelow > 0 && balance < n.rules.BalanceBelow {
+		holding = append(holding, &notification{
+			Alert:     "BalanceLow",
+			Summary:   fmt.Sprintf("BunnyCDN account balance is %g, below %g.", balance, n.rules.BalanceBelow),
+			Value:     balance,
+			Threshold: n.rules.BalanceBelow,
+		})
+	}
+	if days, ok := n.depletionDays(); ok && n.rules.DepletionDays > 0 && days < n.rules.DepletionDays {
+		holding = append(holding, &notification{
+			Alert:     "BalanceDepletion",
+			Summary:   fmt.Sprintf("BunnyCDN account balance of %g will run out in %.1f days at the current rate.", balance, days),
+			Value:     days,
+			Threshold: n.rules.DepletionDays,
+		})
+	}
+	return holding
+}
+
+// pullZoneHolding returns the notifications of the pull zone rules holding
+// for a snapshot.
+func (n *notifier) pullZoneHolding(s *snapshot) []*notification {
+	var holding []*notification
+	for _, pz := range s.PullZones {
+		if budget := n.rules.budget(pz.Name); budget > 0 && pz.MonthlyCharges > budget {
+			holding = append(holding, &notification{
+				Alert:     "ZoneBudgetExceeded",
+				PullZone:  pz.Name,
+				Summary:   fmt.Sprintf("Monthly charges of pull zone %s are %g, above its budget of %g.", pz.Name, pz.MonthlyCharges, budget),
+				Value:     pz.MonthlyCharges,
+				Threshold: budget,
+			})
+		}
+	}
+	return holding
+}
+
+// evaluate sends the notifications that started holding, or are due to be
+// sent again, and those that stopped holding. The account rules are evaluated
+// when the snapshot has the account statistics, and the pull zone rules only
+// when it is complete, so that failing API calls do not resolve
+// notifications.
+func (n *notifier) evaluate(s *snapshot) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	var (
+		now       = s.CollectedAt
+		found     []*notification
+		evaluated = map[string]bool{}
+	)
+	// Without balance history, the balance is the -1 placeholder: the
+	// account rules are left as they are rather than fed a fake balance.
+	if s.Account != nil && len(s.Account.Balance.Points) > 0 {
+		found = append(found, n.accountHolding(s)...)
+		for _, alert := range notifyAccountAlerts {
+			evaluated[alert] = true
+		}
+	}
+	if s.Up && len(s.Errors) == 0 {
+		found = append(found, n.pullZoneHolding(s)...)
+		for _, alert := range notifyPullZoneAlerts {
+			evaluated[alert] = true
+		}
+	}
+
+	holding := map[string]bool{}
+	for _, h := range found {
+		holding[h.key()] = true
+		a, ok := n.active[h.key()]
+		if ok {
+			a.Summary, a.Value, a.Threshold = h.Summary, h.Value, h.Threshold
+		} else {
+			a = h
+			a.Since = now
+			n.active[a.key()] = a
+		}
+		if a.LastSent.IsZero() || now.Sub(a.LastSent) >= n.resend {
+			if n.send("firing", a, now) {
+				a.LastSent = now
+			}
+		}
+	}
+
+	var resolved []string
+	for key, a := range n.active {
+		if evaluated[a.Alert] && !holding[key] {
+			resolved = append(resolved, key)
+		}
+	}
+	sort.Strings(resolved)
+	for _, key := range resolved {
+		a := n.active[key]
+		// Notifications never sent as firing are not resolved either.
+		if !a.LastSent.IsZero() {
+			n.send("resolved", a, now)
+		}
+		delete(n.active, key)
+	}
+}
+
+func (n *notifier) payload(status string, a *notification, now time.Time) interface{} {
+	if n.format == notifyFormatSlack {
+		accountPrefix := ""
+		if n.account != "" {
+			accountPrefix = n.account + " "
+		}
+		return map[string]string{
+			"text": fmt.Sprintf("[%s] %s%s: %s", strings.ToUpper(status), accountPrefix, a.Alert, a.Summary),
+		}
+	}
+	return struct {
+		Status    string    `json:"status"`
+		Alert     string    `json:"alert"`
+		Account   string    `json:"account,omitempty"`
+		PullZone  string    `json:"pull_zone,omitempty"`
+		Summary   string    `json:"summary"`
+		Value     float64   `json:"value"`
+		Threshold float64   `json:"threshold"`
+		Since     time.Time `json:"since"`
+		Time      time.Time `json:"time"`
+	}{status, a.Alert, n.account, a.PullZone, a.Summary, a.Value, a.Threshold, a.Since, now}
+}
+
+// send posts a notification and reports whether it succeeded.
+func (n *notifier) send(status string, a *notification, now time.Time) bool {
+	body, err := json.Marshal(n.payload(status, a, now))
+	if err == nil {
+		err = n.post(body)
+	}
+	if err != nil {
+		log.Errorf("Unable to send %s notification %s: %v", status, a.key(), err)
+		n.errors.Inc()
+		return false
+	}
+	n.sent.Inc()
+	return true
+}
+
+func (n *notifier) post(body []byte) error {
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bunnycdn_exporter")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
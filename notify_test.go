@@ -0,0 +1,176 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func notifySnapshot(at time.Time, balance, charges float64) *snapshot {
+	return &snapshot{
+		CollectedAt: at,
+		Up:          true,
+		Account: &snapshotAccount{Balance: snapshotSeries{
+			Value:  balance,
+			Points: []snapshotPoint{{Time: at, Value: balance}},
+		}},
+		PullZones: []snapshotPullZone{{Name: "zone", MonthlyCharges: charges}},
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	var received []map[string]interface{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error("Unable to decode notification: ", err)
+		}
+		received = append(received, p)
+	}))
+	defer webhook.Close()
+
+	n := newNotifier(webhook.URL, notifyFormatJSON, notifyRules{
+		BalanceBelow:    20,
+		DepletionDays:   3,
+		DepletionWindow: 24 * time.Hour,
+		ZoneBudgets:     map[string]float64{notifyBudgetDefault: 10},
+	}, 4*time.Hour, time.Second)
+	t0 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	n.evaluate(notifySnapshot(t0, 100, 5))
+	assertEqual(t, 0, len(received), "Notifications while no rule holds")
+
+	n.evaluate(notifySnapshot(t0.Add(2*time.Hour), 50, 15))
+	assertEqual(t, 2, len(received), "Notifications of depletion and budget")
+	assertEqual(t, "BalanceDepletion", received[0]["alert"], "Depletion notification")
+	assertEqual(t, "firing", received[0]["status"], "Depletion status")
+	assertEqual(t, "ZoneBudgetExceeded", received[1]["alert"], "Budget notification")
+	assertEqual(t, "zone", received[1]["pull_zone"], "Pull zone over budget")
+	assertEqual(t, 15.0, received[1]["value"], "Charges over budget")
+
+	n.evaluate(notifySnapshot(t0.Add(3*time.Hour), 50, 15))
+	assertEqual(t, 2, len(received), "Notifications are not repeated before the resend interval")
+
+	failed := notifySnapshot(t0.Add(4*time.Hour), 50, 0)
+	failed.Errors = []snapshotError{{Call: "pull_zone_statistics", Error: "timeout"}}
+	n.evaluate(failed)
+	assertEqual(t, 2, len(received), "Budgets are not resolved by incomplete snapshots")
+
+	n.evaluate(notifySnapshot(t0.Add(7*time.Hour), 50, 15))
+	assertEqual(t, 4, len(received), "Notifications are resent after the resend interval")
+
+	n.evaluate(notifySnapshot(t0.Add(8*time.Hour), 50, 5))
+	assertEqual(t, 5, len(received), "Notification of the resolved budget")
+	assertEqual(t, "resolved", received[4]["status"], "Resolved status")
+	assertEqual(t, "ZoneBudgetExceeded", received[4]["alert"], "Resolved notification")
+}
+
+func TestNotifierSlack(t *testing.T) {
+	var text string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]string
+		json.NewDecoder(r.Body).Decode(&p)
+		text = p["text"]
+	}))
+	defer webhook.Close()
+
+	n := newNotifier(webhook.URL, notifyFormatSlack, notifyRules{BalanceBelow: 20}, time.Hour, time.Second)
+	n.evaluate(notifySnapshot(time.Now(), 10, 0))
+	assertEqual(t, "[FIRING] BalanceLow: BunnyCDN account balance is 10, below 20.", text, "Slack message")
+}
+
+func TestNotifierRetriesFailedSends(t *testing.T) {
+	fail := true
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer webhook.Close()
+
+	n := newNotifier(webhook.URL, notifyFormatJSON, notifyRules{BalanceBelow: 20}, time.Hour, time.Second)
+	t0 := time.Now()
+	n.evaluate(notifySnapshot(t0, 10, 0))
+	fail = false
+	n.evaluate(notifySnapshot(t0.Add(time.Minute), 10, 0))
+	assertEqual(t, 1.0, testutil.ToFloat64(n.errors), "Failed notifications")
+	assertEqual(t, 1.0, testutil.ToFloat64(n.sent), "Notification sent on the next evaluation")
+}
+
+func TestNotifierAccountRulesWithFailedPullZones(t *testing.T) {
+	var alerts []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&p)
+		alerts = append(alerts, p["alert"].(string))
+	}))
+	defer webhook.Close()
+
+	n := newNotifier(webhook.URL, notifyFormatJSON, notifyRules{BalanceBelow: 20}, time.Hour, time.Second)
+	s := notifySnapshot(time.Now(), 10, 0)
+	s.Errors = []snapshotError{{Call: "pull_zone_statistics", PullZone: "zone", Error: "timeout"}}
+	n.evaluate(s)
+	assertEqual(t, "BalanceLow", strings.Join(alerts, " "), "Account notifications despite failed pull zones")
+}
+
+func TestNotifierWithoutBalanceHistory(t *testing.T) {
+	var alerts []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&p)
+		alerts = append(alerts, p["alert"].(string)+" "+p["status"].(string))
+	}))
+	defer webhook.Close()
+
+	n := newNotifier(webhook.URL, notifyFormatJSON, notifyRules{
+		BalanceBelow:    20,
+		DepletionDays:   3,
+		DepletionWindow: 24 * time.Hour,
+	}, time.Hour, time.Second)
+	t0 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	n.evaluate(notifySnapshot(t0, 100, 0))
+
+	s := notifySnapshot(t0.Add(time.Hour), -1, 0)
+	s.Account.Balance.Points = []snapshotPoint{}
+	n.evaluate(s)
+	assertEqual(t, 0, len(alerts), "Notifications without balance history")
+	assertEqual(t, 1, len(n.balances), "Balances without balance history")
+
+	n.evaluate(notifySnapshot(t0.Add(2*time.Hour), 10, 0))
+	assertEqual(t, "BalanceLow firing,BalanceDepletion firing", strings.Join(alerts, ","), "Notifications once the balance is known again")
+	assertEqual(t, 2, len(n.balances), "Balances once the balance is known again")
+}
+
+func TestNotifierCollectsWithoutScrapes(t *testing.T) {
+	n := newNotifier("http://127.0.0.1:0", notifyFormatJSON, notifyRules{}, time.Hour, time.Second)
+	collected := make(chan struct{}, 1)
+	go n.run(10*time.Millisecond, func() {
+		select {
+		case collected <- struct{}{}:
+		default:
+		}
+	})
+	select {
+	case <-collected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a collection without scrapes")
+	}
+}
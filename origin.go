@@ -0,0 +1,132 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// originChecker periodically probes the origin of every pull zone directly,
+// so that origin failures can be told apart from edge failures.
+type originChecker struct {
+	pullZones   func() ([]bunnyPullZone, error)
+	client      *http.Client
+	path        string
+	hostHeader  string
+	concurrency int
+
+	mtx     sync.RWMutex
+	results []probeResult
+
+	upDesc, statusDesc, ttfbDesc, durationDesc *prometheus.Desc
+}
+
+func newOriginChecker(pullZones func() ([]bunnyPullZone, error), path, hostHeader string, concurrency int, timeout time.Duration) *originChecker {
+	labels := []string{"pull_zone"}
+	return &originChecker{
+		pullZones:    pullZones,
+		client:       newProbeClient(timeout),
+		path:         path,
+		hostHeader:   hostHeader,
+		concurrency:  concurrency,
+		upDesc:       newMetric("origin_up", "Whether the origin of the pull zone answered without a server error.", labels, nil),
+		statusDesc:   newMetric("origin_status_code", "HTTP status code returned by the origin.", labels, nil),
+		ttfbDesc:     newMetric("origin_ttfb_seconds", "Time to first byte of the origin.", labels, nil),
+		durationDesc: newMetric("origin_duration_seconds", "Total duration of the request to the origin.", labels, nil),
+	}
+}
+
+// targets returns the origin of every pull zone backed by one; zones backed
+// by a storage zone have none. The Host header is the configured one, or the
+// first custom hostname of zones that forward it to their origin.
+func (o *originChecker) targets(pullZones []bunnyPullZone) []probeTarget {
+	var targets []probeTarget
+	for _, pz := range pullZones {
+		if pz.OriginURL == "" {
+			continue
+		}
+		t := probeTarget{
+			PullZone: pz.Name,
+			Path:     o.path,
+			URL:      strings.TrimSuffix(pz.OriginURL, "/") + "/" + strings.TrimPrefix(o.path, "/"),
+			Host:     o.hostHeader,
+		}
+		if t.Host == "" && pz.AddHostHeader {
+			for _, h := range pz.Hostnames {
+				if !h.IsSystemHostname {
+					t.Host = h.Value
+					break
+				}
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+func (o *originChecker) checkAll() {
+	pullZones, err := o.pullZones()
+	if err != nil {
+		log.Errorf("Unable to list pull zones to check origins: %v", err)
+		return
+	}
+	results := runProbes(o.client, o.targets(pullZones), o.concurrency)
+
+	o.mtx.Lock()
+	o.results = results
+	o.mtx.Unlock()
+}
+
+func (o *originChecker) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		o.checkAll()
+		<-ticker.C
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (o *originChecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- o.upDesc
+	ch <- o.statusDesc
+	ch <- o.ttfbDesc
+	ch <- o.durationDesc
+}
+
+// Collect implements prometheus.Collector.
+func (o *originChecker) Collect(ch chan<- prometheus.Metric) {
+	o.mtx.RLock()
+	defer o.mtx.RUnlock()
+
+	for _, r := range o.results {
+		up := 0.0
+		if r.StatusCode > 0 && r.StatusCode < 500 {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(o.upDesc, prometheus.GaugeValue, up, r.PullZone)
+		ch <- prometheus.MustNewConstMetric(o.durationDesc, prometheus.GaugeValue, r.Duration.Seconds(), r.PullZone)
+		if r.StatusCode == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(o.statusDesc, prometheus.GaugeValue, float64(r.StatusCode), r.PullZone)
+		ch <- prometheus.MustNewConstMetric(o.ttfbDesc, prometheus.GaugeValue, r.TTFB.Seconds(), r.PullZone)
+	}
+}
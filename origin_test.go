@@ -0,0 +1,58 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOriginChecker(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "cdn.example.com" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path != "/bucket/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	pullZones := []byte(fmt.Sprintf(`[
+		{"Id": 1,"Name": "forwarded","OriginUrl": "%s/bucket","AddHostHeader": true,"Hostnames": [{"Value": "forwarded.b-cdn.net","IsSystemHostname": true},{"Value": "cdn.example.com"}]},
+		{"Id": 2,"Name": "plain","OriginUrl": "%s/bucket/","AddHostHeader": false,"Hostnames": [{"Value": "cdn.example.com"}]},
+		{"Id": 3,"Name": "storage","OriginUrl": "","StorageZoneId": 42}]`, origin.URL, origin.URL))
+	h := newBunny(pullZones, nil)
+	defer h.Close()
+
+	o := newOriginChecker(newTestPullZoneSource(h.URL, "").list, "/health", "", 2, time.Second)
+	o.checkAll()
+
+	if len(o.results) != 2 {
+		t.Fatal("Number of origins checked: expected: 2, got: ", len(o.results))
+	}
+	assertEqual(t, 200, o.results[0].StatusCode, "Status code of origin with forwarded host")
+	assertEqual(t, 502, o.results[1].StatusCode, "Status code of origin without forwarded host")
+
+	ch := make(chan prometheus.Metric, 10)
+	o.Collect(ch)
+	close(ch)
+	assertEqual(t, 8, len(ch), "Number of origin series")
+}
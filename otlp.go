@@ -0,0 +1,391 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+)
+
+// The following types are the subset of the OTLP metrics data model needed
+// to encode the exporter's metrics, with their OTLP/HTTP JSON field names.
+
+const otlpTemporalityCumulative = 2
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit,omitempty"`
+	Help      string         `json:"description,omitempty"`
+	Gauge     *otlpGauge     `json:"gauge,omitempty"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+	Summary   *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+// 64 bit integers are encoded as strings in OTLP JSON.
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes     []otlpAttribute     `json:"attributes,omitempty"`
+	TimeUnixNano   string              `json:"timeUnixNano"`
+	Count          string              `json:"count"`
+	Sum            float64             `json:"sum"`
+	QuantileValues []otlpQuantileValue `json:"quantileValues"`
+}
+
+type otlpQuantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func otlpAttributes(labels map[string]string) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(labels))
+	for k, v := range labels {
+		attrs = append(attrs, otlpAttribute{Key: k, Value: otlpAnyValue{StringValue: v}})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// toOTLPMetrics converts gathered metric families to OTLP metrics. Metric
+// labels become data point attributes. Counters are cumulative sums starting
+// at start. Gauges named _total hold the daily totals of BunnyCDN, which reset
+// at midnight UTC: they are cumulative sums starting at midnight of the day of
+// their sample. Other gauges stay gauges.
+func toOTLPMetrics(mfs []*dto.MetricFamily, start, now time.Time) []otlpMetric {
+	var metrics []otlpMetric
+	for _, mf := range mfs {
+		m := otlpMetric{Name: mf.GetName(), Help: mf.GetHelp()}
+		if strings.HasSuffix(m.Name, "_bytes") || strings.HasSuffix(m.Name, "_bytes_total") {
+			m.Unit = "By"
+		} else if strings.HasSuffix(m.Name, "_seconds") {
+			m.Unit = "s"
+		}
+
+		for _, pm := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range pm.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			attrs := otlpAttributes(labels)
+			at := now
+			if pm.TimestampMs != nil {
+				at = time.Unix(0, pm.GetTimestampMs()*int64(time.Millisecond))
+			}
+			ts := otlpTime(at)
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+				value := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_COUNTER {
+					value = pm.GetCounter().GetValue()
+				} else if mf.GetType() == dto.MetricType_UNTYPED {
+					value = pm.GetUntyped().GetValue()
+				}
+				p := otlpNumberDataPoint{Attributes: attrs, TimeUnixNano: ts, AsDouble: value}
+				daily := mf.GetType() == dto.MetricType_GAUGE && strings.HasSuffix(m.Name, "_total")
+				if mf.GetType() != dto.MetricType_COUNTER && !daily {
+					if m.Gauge == nil {
+						m.Gauge = &otlpGauge{}
+					}
+					m.Gauge.DataPoints = append(m.Gauge.DataPoints, p)
+					continue
+				}
+				if m.Sum == nil {
+					m.Sum = &otlpSum{
+						AggregationTemporality: otlpTemporalityCumulative,
+						IsMonotonic:            true,
+					}
+				}
+				p.StartTimeUnixNano = otlpTime(start)
+				if daily {
+					day := at.UTC()
+					p.StartTimeUnixNano = otlpTime(time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC))
+				}
+				m.Sum.DataPoints = append(m.Sum.DataPoints, p)
+			case dto.MetricType_HISTOGRAM:
+				h := pm.GetHistogram()
+				p := otlpHistogramDataPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: otlpTime(start),
+					TimeUnixNano:      ts,
+					Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+					Sum:               h.GetSampleSum(),
+				}
+				// OTLP buckets are not cumulative, and the last one is
+				// the implicit +Inf bucket.
+				var prev uint64
+				for _, b := range h.GetBucket() {
+					p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+					p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+					prev = b.GetCumulativeCount()
+				}
+				p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+				if m.Histogram == nil {
+					m.Histogram = &otlpHistogram{AggregationTemporality: otlpTemporalityCumulative}
+				}
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+			case dto.MetricType_SUMMARY:
+				s := pm.GetSummary()
+				p := otlpSummaryDataPoint{
+					Attributes:   attrs,
+					TimeUnixNano: ts,
+					Count:        strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:          s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					p.QuantileValues = append(p.QuantileValues, otlpQuantileValue{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+				if m.Summary == nil {
+					m.Summary = &otlpSummary{}
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+			}
+		}
+		if m.Gauge != nil || m.Sum != nil || m.Histogram != nil || m.Summary != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// otlpConfig configures exporting metrics with OTLP/HTTP.
+type otlpConfig struct {
+	// Endpoint is the URL metrics are posted to. A URL without a path gets
+	// the default /v1/metrics.
+	Endpoint           string
+	Headers            map[string]string
+	ResourceAttributes map[string]string
+	Timeout            time.Duration
+}
+
+// otlpExporter periodically gathers metrics and exports them to an
+// OpenTelemetry collector.
+type otlpExporter struct {
+	cfg      otlpConfig
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	start    time.Time
+
+	exports, failures prometheus.Counter
+}
+
+func newOTLPExporter(cfg otlpConfig, gatherer prometheus.Gatherer) (*otlpExporter, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %v", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	cfg.Endpoint = u.String()
+
+	return &otlpExporter{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		gatherer: gatherer,
+		start:    time.Now(),
+		exports: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_otlp_exports_total",
+			Help:      "Number of OTLP metrics exports.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_otlp_export_failures_total",
+			Help:      "Number of failed OTLP metrics exports.",
+		}),
+	}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (o *otlpExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- o.exports.Desc()
+	ch <- o.failures.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (o *otlpExporter) Collect(ch chan<- prometheus.Metric) {
+	ch <- o.exports
+	ch <- o.failures
+}
+
+func (o *otlpExporter) request(now time.Time) (*otlpRequest, error) {
+	mfs, err := o.gatherer.Gather()
+	if err != nil && len(mfs) == 0 {
+		return nil, err
+	}
+	if err != nil {
+		log.Errorf("Error gathering metrics for OTLP: %v", err)
+	}
+
+	resource := map[string]string{
+		"service.name":    "bunnycdn_exporter",
+		"service.version": version.Version,
+	}
+	for k, v := range o.cfg.ResourceAttributes {
+		resource[k] = v
+	}
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: otlpAttributes(resource)},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "bunnycdn_exporter", Version: version.Version},
+			Metrics: toOTLPMetrics(mfs, o.start, now),
+		}},
+	}}}, nil
+}
+
+func (o *otlpExporter) export() error {
+	o.exports.Inc()
+	err := o.post()
+	if err != nil {
+		o.failures.Inc()
+	}
+	return err
+}
+
+func (o *otlpExporter) post() error {
+	r, err := o.request(time.Now())
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", o.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bunnycdn_exporter")
+	for k, v := range o.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// run exports at every interval until ctx is done. An export in progress is
+// completed.
+func (o *otlpExporter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := o.export(); err != nil {
+			log.Errorf("Unable to export metrics with OTLP: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestOTLPExport(t *testing.T) {
+	var (
+		path, auth string
+		received   otlpRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error("Unable to decode export: ", err)
+		}
+	}))
+	defer collector.Close()
+
+	h := newBunny([]byte(`[{"Id": 1, "Name": "zone"}]`), []byte(`{
+		"BandwidthUsedChart": {"2019-05-02T00:00:00": 100},
+		"GeoTrafficDistribution": {"EU: London, GB": 10}
+	}`))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+
+	o, err := newOTLPExporter(otlpConfig{
+		Endpoint:           collector.URL,
+		Headers:            map[string]string{"Authorization": "Bearer token"},
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+		Timeout:            time.Second,
+	}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.export(); err != nil {
+		t.Fatal("Unexpected error exporting metrics: ", err)
+	}
+	assertEqual(t, "/v1/metrics", path, "Default OTLP path")
+	assertEqual(t, "Bearer token", auth, "Configured header")
+
+	rm := received.ResourceMetrics[0]
+	resource := map[string]string{}
+	for _, a := range rm.Resource.Attributes {
+		resource[a.Key] = a.Value.StringValue
+	}
+	assertEqual(t, "bunnycdn_exporter", resource["service.name"], "Service name")
+	assertEqual(t, "test", resource["deployment.environment"], "Configured resource attribute")
+
+	metrics := map[string]otlpMetric{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	bandwidth := metrics["bunnycdn_bandwidth_used_bytes_total"]
+	if bandwidth.Sum == nil {
+		t.Fatal("Bandwidth total should be exported as a sum")
+	}
+	assertEqual(t, otlpTemporalityCumulative, bandwidth.Sum.AggregationTemporality, "Temporality of totals")
+	assertEqual(t, true, bandwidth.Sum.IsMonotonic, "Totals are monotonic")
+	assertEqual(t, "By", bandwidth.Unit, "Bandwidth unit")
+	assertEqual(t, 100.0, bandwidth.Sum.DataPoints[0].AsDouble, "Bandwidth value")
+	assertEqual(t, "pull_zone", bandwidth.Sum.DataPoints[0].Attributes[0].Key, "Pull zone attribute")
+	assertEqual(t, "zone", bandwidth.Sum.DataPoints[0].Attributes[0].Value.StringValue, "Pull zone attribute value")
+
+	scrapes := metrics["bunnycdn_exporter_total_scrapes"]
+	if scrapes.Sum == nil {
+		t.Fatal("Counters should be exported as sums")
+	}
+	assertEqual(t, otlpTemporalityCumulative, scrapes.Sum.AggregationTemporality, "Temporality of counters")
+	assertEqual(t, true, scrapes.Sum.IsMonotonic, "Counters are monotonic")
+
+	geo := metrics["bunnycdn_requests_served"]
+	if geo.Gauge == nil {
+		t.Fatal("Geo distribution should be exported as a gauge")
+	}
+	attrs := geo.Gauge.DataPoints[0].Attributes
+	assertEqual(t, 3, len(attrs), "Geo attributes")
+	assertEqual(t, "London, GB", attrs[0].Value.StringValue, "Location attribute")
+}
+
+func TestOTLPHistogram(t *testing.T) {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size_bytes", Buckets: []float64{1, 10}})
+	for _, v := range []float64{0.5, 5, 5, 50} {
+		h.Observe(v)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(h)
+	mfs, _ := reg.Gather()
+
+	metrics := toOTLPMetrics(mfs, time.Unix(0, 0), time.Unix(1, 0))
+	p := metrics[0].Histogram.DataPoints[0]
+	assertEqual(t, "4", p.Count, "Histogram count")
+	assertEqual(t, "1 2 1", strings.Join(p.BucketCounts, " "), "Histogram buckets")
+	assertEqual(t, "0", p.StartTimeUnixNano, "Histogram start time")
+}
+
+func TestOTLPDailyTotals(t *testing.T) {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "requests_served_total"})
+	g.Set(10)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(g)
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "up"}, func() float64 { return 1 }))
+	mfs, _ := reg.Gather()
+
+	now := time.Date(2019, 5, 2, 13, 30, 0, 0, time.UTC)
+	metrics := map[string]otlpMetric{}
+	for _, m := range toOTLPMetrics(mfs, time.Unix(0, 0), now) {
+		metrics[m.Name] = m
+	}
+	total := metrics["requests_served_total"]
+	if total.Sum == nil {
+		t.Fatal("Daily totals should be exported as sums")
+	}
+	assertEqual(t, true, total.Sum.IsMonotonic, "Daily totals are monotonic")
+	assertEqual(t, otlpTime(time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)), total.Sum.DataPoints[0].StartTimeUnixNano, "Start of daily totals")
+	assertEqual(t, otlpTime(now), total.Sum.DataPoints[0].TimeUnixNano, "Time of daily totals")
+	if metrics["up"].Gauge == nil {
+		t.Fatal("Other gauges should stay gauges")
+	}
+
+	stamped := []*dto.MetricFamily{{
+		Name: proto.String("requests_served_total"),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Gauge:       &dto.Gauge{Value: proto.Float64(5)},
+			TimestampMs: proto.Int64(time.Date(2019, 5, 1, 23, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)),
+		}},
+	}}
+	p := toOTLPMetrics(stamped, time.Unix(0, 0), now)[0].Sum.DataPoints[0]
+	assertEqual(t, otlpTime(time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)), p.StartTimeUnixNano, "Start at midnight of the chart day")
+}
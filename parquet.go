@@ -0,0 +1,268 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// This file implements just enough of Parquet to write flat tables: a single
+// row group of required columns, with one uncompressed, PLAIN encoded data
+// page per column.
+
+const parquetMagic = "PAR1"
+
+// Parquet physical and converted types, and Thrift compact protocol types.
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetConvertedNone = -1
+	parquetConvertedUTF8 = 0
+	parquetConvertedDate = 6
+
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetColumn is a column of a table. Values is a []string, []int64,
+// []float64, or a []time.Time of dates.
+type parquetColumn struct {
+	Name   string
+	Values interface{}
+}
+
+// encode returns the physical and converted type of the column, its number
+// of values and their PLAIN encoding.
+func (c parquetColumn) encode() (typ, converted int32, n int, data []byte, err error) {
+	var buf bytes.Buffer
+	switch values := c.Values.(type) {
+	case []string:
+		for _, v := range values {
+			binary.Write(&buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		}
+		return parquetByteArray, parquetConvertedUTF8, len(values), buf.Bytes(), nil
+	case []int64:
+		binary.Write(&buf, binary.LittleEndian, values)
+		return parquetInt64, parquetConvertedNone, len(values), buf.Bytes(), nil
+	case []float64:
+		for _, v := range values {
+			binary.Write(&buf, binary.LittleEndian, math.Float64bits(v))
+		}
+		return parquetDouble, parquetConvertedNone, len(values), buf.Bytes(), nil
+	case []time.Time:
+		// Dates are days since the Unix epoch.
+		for _, v := range values {
+			binary.Write(&buf, binary.LittleEndian, int32(v.Unix()/(24*60*60)))
+		}
+		return parquetInt32, parquetConvertedDate, len(values), buf.Bytes(), nil
+	}
+	return 0, 0, 0, nil, fmt.Errorf("unsupported type %T of parquet column %s", c.Values, c.Name)
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol, in which
+// Parquet metadata is serialized.
+type thriftWriter struct {
+	bytes.Buffer
+	// lastID holds the last field written of each nested struct.
+	lastID []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{lastID: []int16{0}}
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.lastID[len(t.lastID)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.rawStr(s)
+}
+
+func (t *thriftWriter) rawStr(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+// list starts a list field; its n elements are written next, structs being
+// delimited with begin and end.
+func (t *thriftWriter) list(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		t.WriteByte(0xf0 | elemType)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+func (t *thriftWriter) begin() {
+	t.lastID = append(t.lastID, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	t.lastID = t.lastID[:len(t.lastID)-1]
+}
+
+type parquetChunk struct {
+	typ, converted int32
+	n              int
+	offset, size   int64
+}
+
+// writeParquet writes the columns, which must all have numRows values, as a
+// Parquet file.
+func writeParquet(w io.Writer, columns []parquetColumn, numRows int) error {
+	if _, err := io.WriteString(w, parquetMagic); err != nil {
+		return err
+	}
+	offset := int64(len(parquetMagic))
+
+	chunks := make([]parquetChunk, len(columns))
+	for i, c := range columns {
+		typ, converted, n, data, err := c.encode()
+		if err != nil {
+			return err
+		}
+		if n != numRows {
+			return fmt.Errorf("parquet column %s has %d values instead of %d", c.Name, n, numRows)
+		}
+
+		// PageHeader of a DataPageHeader. Required columns have neither
+		// repetition nor definition levels.
+		h := newThriftWriter()
+		h.i32(1, 0)
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.structField(5)
+		h.i32(1, int32(n))
+		h.i32(2, 0)
+		h.i32(3, 3)
+		h.i32(4, 3)
+		h.end()
+		h.end()
+
+		size := int64(h.Len() + len(data))
+		chunks[i] = parquetChunk{typ: typ, converted: converted, n: n, offset: offset, size: size}
+		if _, err := w.Write(h.Bytes()); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		offset += size
+	}
+
+	// FileMetaData.
+	m := newThriftWriter()
+	m.i32(1, 1)
+	m.list(2, thriftStruct, len(columns)+1)
+	m.begin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(columns)))
+	m.end()
+	for i, c := range columns {
+		m.begin()
+		m.i32(1, chunks[i].typ)
+		m.i32(3, 0)
+		m.str(4, c.Name)
+		if chunks[i].converted != parquetConvertedNone {
+			m.i32(6, chunks[i].converted)
+		}
+		m.end()
+	}
+	m.i64(3, int64(numRows))
+	m.list(4, thriftStruct, 1)
+	m.begin()
+	m.list(1, thriftStruct, len(columns))
+	var total int64
+	for i, c := range columns {
+		ch := chunks[i]
+		total += ch.size
+		m.begin()
+		m.i64(2, ch.offset)
+		m.structField(3)
+		m.i32(1, ch.typ)
+		m.list(2, thriftI32, 1)
+		m.varint(zigzag(0))
+		m.list(3, thriftBinary, 1)
+		m.rawStr(c.Name)
+		m.i32(4, 0)
+		m.i64(5, int64(ch.n))
+		m.i64(6, ch.size)
+		m.i64(7, ch.size)
+		m.i64(9, ch.offset)
+		m.end()
+		m.end()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(numRows))
+	m.end()
+	m.str(6, "bunnycdn_exporter")
+	m.end()
+
+	if _, err := w.Write(m.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(m.Len())); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, parquetMagic)
+	return err
+}
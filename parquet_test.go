@@ -0,0 +1,147 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// thriftReader decodes the Thrift compact protocol into maps of field IDs,
+// slices, int64 and strings, to check the metadata written.
+type thriftReader struct {
+	b []byte
+	i int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b[r.i:])
+	r.i += n
+	return v
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return unzigzag(r.varint())
+	case thriftBinary:
+		n := int(r.varint())
+		r.i += n
+		return string(r.b[r.i-n : r.i])
+	case thriftList:
+		h := r.b[r.i]
+		r.i++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(h & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		h := r.b[r.i]
+		r.i++
+		if h == 0 {
+			return fields
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(unzigzag(r.varint()))
+		}
+		fields[id] = r.value(h & 0x0f)
+		last = id
+	}
+}
+
+func TestThriftWriter(t *testing.T) {
+	w := newThriftWriter()
+	w.i32(1, 1)
+	w.i64(3, -2)
+	w.str(20, "ab")
+	w.end()
+	// Short and long field headers, zigzag varints, and the stop field.
+	assertEqual(t, "\x15\x02\x26\x03\x08\x28\x02ab\x00", w.String(), "Compact protocol encoding")
+}
+
+func TestWriteParquet(t *testing.T) {
+	var out bytes.Buffer
+	err := writeParquet(&out, []parquetColumn{
+		{"date", []time.Time{time.Date(1970, 1, 3, 0, 0, 0, 0, time.UTC)}},
+		{"name", []string{"zone"}},
+		{"value", []float64{1.5}},
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := out.Bytes()
+	assertEqual(t, parquetMagic, string(b[:4]), "Leading magic")
+	assertEqual(t, parquetMagic, string(b[len(b)-4:]), "Trailing magic")
+	footer := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	if footer <= 0 || footer > len(b)-12 {
+		t.Fatalf("Invalid footer length %d for a file of %d bytes", footer, len(b))
+	}
+
+	r := &thriftReader{b: b[len(b)-8-footer : len(b)-8]}
+	meta := r.structure()
+	assertEqual(t, len(b)-8, len(b)-8-footer+r.i, "Footer fully decoded")
+	assertEqual(t, int64(1), meta[1], "Format version")
+	assertEqual(t, int64(1), meta[3], "Number of rows")
+	assertEqual(t, "bunnycdn_exporter", meta[6], "Created by")
+	schema := meta[2].([]interface{})
+	assertEqual(t, 4, len(schema), "Schema elements")
+	assertEqual(t, int64(3), schema[0].(map[int16]interface{})[5], "Number of columns")
+	assertEqual(t, "name", schema[2].(map[int16]interface{})[4], "Column name")
+	assertEqual(t, int64(parquetConvertedUTF8), schema[2].(map[int16]interface{})[6], "Converted type")
+
+	rowGroups := meta[4].([]interface{})
+	assertEqual(t, 1, len(rowGroups), "Row groups")
+	chunks := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+	assertEqual(t, 3, len(chunks), "Column chunks")
+	// The values are read back from the data page of every column.
+	var values []string
+	for _, c := range chunks {
+		cm := c.(map[int16]interface{})[3].(map[int16]interface{})
+		offset, size := cm[9].(int64), cm[6].(int64)
+		page := &thriftReader{b: b[offset : offset+size]}
+		header := page.structure()
+		assertEqual(t, int64(len(page.b)-page.i), header[2], "Data page size")
+		values = append(values, string(page.b[page.i:]))
+	}
+	assertEqual(t, "\x02\x00\x00\x00", values[0], "Date column")
+	assertEqual(t, "\x04\x00\x00\x00zone", values[1], "String column")
+	assertEqual(t, 1.5, math.Float64frombits(binary.LittleEndian.Uint64([]byte(values[2]))), "Double column")
+
+	if err := writeParquet(&out, []parquetColumn{{"value", []float64{1}}}, 2); err == nil {
+		t.Fatal("Expected an error for a column with a wrong number of values")
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+var probeLabels = []string{"pull_zone", "hostname", "path"}
+
+// probeMaxBodySize bounds the part of a response body that is downloaded.
+const probeMaxBodySize = 10 << 20
+
+type probeTarget struct {
+	PullZone string
+	Hostname string
+	Path     string
+	URL      string
+	// Host, if set, overrides the Host header of the request.
+	Host string
+}
+
+type probeResult struct {
+	probeTarget
+
+	Success      bool
+	StatusCode   int
+	TTFB         time.Duration
+	Duration     time.Duration
+	TLSHandshake time.Duration
+	CDNCache     string
+	Server       string
+}
+
+// prober periodically performs HTTP requests against every hostname of every
+// pull zone, to measure health as seen by users.
+type prober struct {
+	pullZones   func() ([]bunnyPullZone, error)
+	client      *http.Client
+	paths       []string
+	concurrency int
+
+	mtx     sync.RWMutex
+	results []probeResult
+
+	successDesc, statusDesc, ttfbDesc, durationDesc, tlsDesc, infoDesc *prometheus.Desc
+}
+
+func newProber(pullZones func() ([]bunnyPullZone, error), paths []string, concurrency int, timeout time.Duration) *prober {
+	return &prober{
+		pullZones:    pullZones,
+		client:       newProbeClient(timeout),
+		paths:        paths,
+		concurrency:  concurrency,
+		successDesc:  newMetric("probe_success", "Whether the last probe of the hostname succeeded.", probeLabels, nil),
+		statusDesc:   newMetric("probe_status_code", "HTTP status code returned by the last probe.", probeLabels, nil),
+		ttfbDesc:     newMetric("probe_ttfb_seconds", "Time to first byte of the last probe.", probeLabels, nil),
+		durationDesc: newMetric("probe_duration_seconds", "Total duration of the last probe.", probeLabels, nil),
+		tlsDesc:      newMetric("probe_tls_handshake_seconds", "Duration of the TLS handshake of the last probe.", probeLabels, nil),
+		infoDesc:     newMetric("probe_info", "Headers returned by the last probe.", append(probeLabels, "cdn_cache", "server"), nil),
+	}
+}
+
+// newProbeClient returns a client that does not follow redirects, and opens
+// a new connection for every probe so that the TLS handshake is measured each
+// time.
+func newProbeClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// uniqueProbePaths returns the paths starting with a slash, without
+// duplicates, which would be duplicate series.
+func uniqueProbePaths(paths []string) []string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
+
+func (p *prober) targets(pullZones []bunnyPullZone) []probeTarget {
+	var targets []probeTarget
+	for _, pz := range pullZones {
+		for _, h := range pz.Hostnames {
+			scheme := "http://"
+			if h.HasCertificate || h.ForceSSL {
+				scheme = "https://"
+			}
+			for _, path := range p.paths {
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+				targets = append(targets, probeTarget{
+					PullZone: pz.Name,
+					Hostname: h.Value,
+					Path:     path,
+					URL:      scheme + h.Value + path,
+				})
+			}
+		}
+	}
+	return targets
+}
+
+func probe(client *http.Client, t probeTarget) probeResult {
+	r := probeResult{probeTarget: t}
+
+	req, err := http.NewRequest("GET", t.URL, nil)
+	if err != nil {
+		log.Errorf("Unable to probe %s: %v", t.URL, err)
+		return r
+	}
+	if t.Host != "" {
+		req.Host = t.Host
+	}
+	var start, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.TLSHandshake = time.Since(tlsStart)
+		},
+		GotFirstResponseByte: func() { r.TTFB = time.Since(start) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		r.Duration = time.Since(start)
+		log.Debugf("Probe of %s failed: %v", t.URL, err)
+		return r
+	}
+	_, err = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, probeMaxBodySize))
+	resp.Body.Close()
+	r.Duration = time.Since(start)
+
+	r.StatusCode = resp.StatusCode
+	r.CDNCache = resp.Header.Get("CDN-Cache")
+	r.Server = resp.Header.Get("Server")
+	r.Success = err == nil && resp.StatusCode >= 200 && resp.StatusCode < 400
+	return r
+}
+
+// runProbes probes the targets with at most concurrency requests in flight,
+// and returns the results in the same order.
+func runProbes(client *http.Client, targets []probeTarget, concurrency int) []probeResult {
+	results := make([]probeResult, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t probeTarget) {
+			defer wg.Done()
+			results[i] = probe(client, t)
+			<-sem
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+func (p *prober) probeAll() {
+	pullZones, err := p.pullZones()
+	if err != nil {
+		log.Errorf("Unable to list pull zones to probe: %v", err)
+		return
+	}
+	results := runProbes(p.client, p.targets(pullZones), p.concurrency)
+
+	p.mtx.Lock()
+	p.results = results
+	p.mtx.Unlock()
+}
+
+func (p *prober) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.probeAll()
+		<-ticker.C
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (p *prober) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.successDesc
+	ch <- p.statusDesc
+	ch <- p.ttfbDesc
+	ch <- p.durationDesc
+	ch <- p.tlsDesc
+	ch <- p.infoDesc
+}
+
+// Collect implements prometheus.Collector.
+func (p *prober) Collect(ch chan<- prometheus.Metric) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, r := range p.results {
+		success := 0.0
+		if r.Success {
+			success = 1
+		}
+		ch <- prometheus.MustNewConstMetric(p.successDesc, prometheus.GaugeValue, success, r.PullZone, r.Hostname, r.Path)
+		ch <- prometheus.MustNewConstMetric(p.durationDesc, prometheus.GaugeValue, r.Duration.Seconds(), r.PullZone, r.Hostname, r.Path)
+		if r.StatusCode == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(p.statusDesc, prometheus.GaugeValue, float64(r.StatusCode), r.PullZone, r.Hostname, r.Path)
+		ch <- prometheus.MustNewConstMetric(p.ttfbDesc, prometheus.GaugeValue, r.TTFB.Seconds(), r.PullZone, r.Hostname, r.Path)
+		if strings.HasPrefix(r.URL, "https://") {
+			ch <- prometheus.MustNewConstMetric(p.tlsDesc, prometheus.GaugeValue, r.TLSHandshake.Seconds(), r.PullZone, r.Hostname, r.Path)
+		}
+		ch <- prometheus.MustNewConstMetric(p.infoDesc, prometheus.GaugeValue, 1, r.PullZone, r.Hostname, r.Path, r.CDNCache, r.Server)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProber(t *testing.T) {
+	cdn := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CDN-Cache", "HIT")
+		w.Header().Set("Server", "BunnyCDN-DE1-123")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer cdn.Close()
+	hostname := strings.TrimPrefix(cdn.URL, "https://")
+
+	pullZones := []byte(fmt.Sprintf(`[{"Id": 12345,"Name": "pullzonename","Hostnames": [{"Id": 54321,"Value": %q,"HasCertificate": true},{"Id": 54322,"Value": "127.0.0.1:1","HasCertificate": false}]}]`, hostname))
+	h := newBunny(pullZones, nil)
+	defer h.Close()
+
+	p := newProber(newTestPullZoneSource(h.URL, "").list, []string{"/", "missing"}, 2, time.Second)
+	p.client.Transport = cdn.Client().Transport
+	p.probeAll()
+
+	if len(p.results) != 4 {
+		t.Fatal("Number of probes: expected: 4, got: ", len(p.results))
+	}
+	r := p.results[0]
+	assertEqual(t, hostname, r.Hostname, "Hostname probed")
+	assertEqual(t, true, r.Success, "Probe success")
+	assertEqual(t, 200, r.StatusCode, "Status code")
+	assertEqual(t, "HIT", r.CDNCache, "CDN-Cache header")
+	assertEqual(t, "BunnyCDN-DE1-123", r.Server, "Server header")
+	if r.TLSHandshake <= 0 || r.TTFB <= 0 || r.Duration < r.TTFB {
+		t.Fatalf("Unexpected timings: TLS handshake %v, TTFB %v, total %v", r.TLSHandshake, r.TTFB, r.Duration)
+	}
+
+	r = p.results[1]
+	assertEqual(t, "/missing", r.Path, "Path probed")
+	assertEqual(t, false, r.Success, "Probe success of missing path")
+	assertEqual(t, 404, r.StatusCode, "Status code of missing path")
+
+	r = p.results[2]
+	assertEqual(t, false, r.Success, "Probe success of unreachable hostname")
+	assertEqual(t, 0, r.StatusCode, "Status code of unreachable hostname")
+}
+
+func TestProbeTLSHandshakeOfPlainHTTP(t *testing.T) {
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer cdn.Close()
+	hostname := strings.TrimPrefix(cdn.URL, "http://")
+
+	pullZones := []byte(fmt.Sprintf(`[{"Id": 12345,"Name": "pullzonename","Hostnames": [{"Id": 54321,"Value": %q}]}]`, hostname))
+	h := newBunny(pullZones, nil)
+	defer h.Close()
+
+	p := newProber(newTestPullZoneSource(h.URL, "").list, []string{"/"}, 1, time.Second)
+	p.probeAll()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(p)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "bunnycdn_probe_tls_handshake_seconds" {
+			t.Fatal("TLS handshake of a plain HTTP probe should not be exported")
+		}
+	}
+	assertEqual(t, 200, p.results[0].StatusCode, "Status code of a plain HTTP probe")
+}
+
+func TestUniqueProbePaths(t *testing.T) {
+	assertEqual(t, "/ /a /b", strings.Join(uniqueProbePaths([]string{"/", "a", "/a", "/b", "/"}), " "), "Unique probe paths")
+}
+
+func TestProbeTargetsScheme(t *testing.T) {
+	p := newProber(nil, []string{"/"}, 1, time.Second)
+	targets := p.targets([]bunnyPullZone{{Name: "pullzonename", Hostnames: []bunnyHostname{
+		{Value: "plain.example.com"},
+		{Value: "forced.example.com", ForceSSL: true},
+		{Value: "cert.example.com", HasCertificate: true},
+	}}})
+	assertEqual(t, "http://plain.example.com/", targets[0].URL, "URL without TLS")
+	assertEqual(t, "https://forced.example.com/", targets[1].URL, "URL forcing TLS")
+	assertEqual(t, "https://cert.example.com/", targets[2].URL, "URL with a certificate")
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/log"
+)
+
+// pushConfig configures pushing to a Pushgateway instead of being scraped.
+type pushConfig struct {
+	URL      string
+	Job      string
+	Grouping map[string]string
+	Username string
+	Password string
+	// Interval between pushes. If zero, metrics are pushed once.
+	Interval time.Duration
+}
+
+func newPusher(cfg pushConfig, g prometheus.Gatherer) *push.Pusher {
+	p := push.New(cfg.URL, cfg.Job).Gatherer(g)
+	for name, value := range cfg.Grouping {
+		p = p.Grouping(name, value)
+	}
+	if cfg.Username != "" {
+		p = p.BasicAuth(cfg.Username, cfg.Password)
+	}
+	return p
+}
+
+// runPush collects and pushes metrics. When pushing once, the error of the
+// push is returned; when pushing repeatedly, errors are logged and the next
+// push is attempted at the following interval, until ctx is done. The
+// gatherer is expected to fail the collections cancelled by the shutdown, so
+// that the Pushgateway is not left with a failed collection.
+func runPush(ctx context.Context, cfg pushConfig, g prometheus.Gatherer) error {
+	p := newPusher(cfg, g)
+	if cfg.Interval <= 0 {
+		return p.Push()
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Push(); err != nil {
+			log.Errorf("Unable to push metrics to %s: %v", cfg.URL, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPush(t *testing.T) {
+	var (
+		method, path, user, pass string
+		body                     []byte
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		user, pass, _ = r.BasicAuth()
+		body, _ = ioutil.ReadAll(r.Body)
+		if user != "pusher" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	h := newBunny([]byte(`[]`), []byte(`{"UserBalanceHistoryChart": {"2019-05-02T00:37:51": 1000}}`))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+
+	cfg := pushConfig{
+		URL:      gateway.URL,
+		Job:      "bunnycdn",
+		Grouping: map[string]string{"env": "test"},
+		Username: "pusher",
+		Password: "secret",
+	}
+	if err := runPush(context.Background(), cfg, reg); err != nil {
+		t.Fatal("Unexpected error pushing metrics: ", err)
+	}
+	assertEqual(t, "PUT", method, "Push method")
+	assertEqual(t, "/metrics/job/bunnycdn/env/test", path, "Push path")
+	assertEqual(t, "secret", pass, "Basic auth password")
+	if !bytes.Contains(body, []byte("bunnycdn_account_balance")) {
+		t.Fatal("Pushed metrics do not contain the account balance")
+	}
+
+	cfg.Username = "someone"
+	if err := runPush(context.Background(), cfg, reg); err == nil {
+		t.Fatal("Expected an error when the push is rejected")
+	}
+}
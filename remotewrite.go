@@ -0,0 +1,324 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// The following types mirror the messages of the Prometheus remote write
+// protocol (prompb), so that they can be marshalled by the protobuf package
+// without depending on the whole of Prometheus.
+
+type rwWriteRequest struct {
+	Timeseries []*rwTimeSeries `protobuf:"bytes,1,rep,name=timeseries"`
+}
+
+func (m *rwWriteRequest) Reset()         { *m = rwWriteRequest{} }
+func (m *rwWriteRequest) String() string { return proto.CompactTextString(m) }
+func (*rwWriteRequest) ProtoMessage()    {}
+
+type rwTimeSeries struct {
+	Labels  []*rwLabel  `protobuf:"bytes,1,rep,name=labels"`
+	Samples []*rwSample `protobuf:"bytes,2,rep,name=samples"`
+}
+
+func (m *rwTimeSeries) Reset()         { *m = rwTimeSeries{} }
+func (m *rwTimeSeries) String() string { return proto.CompactTextString(m) }
+func (*rwTimeSeries) ProtoMessage()    {}
+
+type rwLabel struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *rwLabel) Reset()         { *m = rwLabel{} }
+func (m *rwLabel) String() string { return proto.CompactTextString(m) }
+func (*rwLabel) ProtoMessage()    {}
+
+type rwSample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value,proto3"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp,proto3"`
+}
+
+func (m *rwSample) Reset()         { *m = rwSample{} }
+func (m *rwSample) String() string { return proto.CompactTextString(m) }
+func (*rwSample) ProtoMessage()    {}
+
+// toTimeSeries flattens gathered metric families into one series per sample,
+// the way they would be scraped. Samples without a timestamp get now.
+func toTimeSeries(mfs []*dto.MetricFamily, extraLabels map[string]string, now time.Time) []*rwTimeSeries {
+	var series []*rwTimeSeries
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...string) {
+				labels := []*rwLabel{{Name: "__name__", Value: name + suffix}}
+				for k, v := range extraLabels {
+					labels = append(labels, &rwLabel{Name: k, Value: v})
+				}
+				for _, l := range m.GetLabel() {
+					labels = append(labels, &rwLabel{Name: l.GetName(), Value: l.GetValue()})
+				}
+				for i := 0; i < len(extra); i += 2 {
+					labels = append(labels, &rwLabel{Name: extra[i], Value: extra[i+1]})
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, &rwTimeSeries{
+					Labels:  labels,
+					Samples: []*rwSample{{Value: value, Timestamp: ts}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add("_sum", m.GetSummary().GetSampleSum())
+				add("_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				for _, b := range m.GetHistogram().GetBucket() {
+					add("_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				add("_bucket", float64(m.GetHistogram().GetSampleCount()), "le", "+Inf")
+				add("_sum", m.GetHistogram().GetSampleSum())
+				add("_count", float64(m.GetHistogram().GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// remoteWriteConfig configures sending metrics to a remote write endpoint.
+type remoteWriteConfig struct {
+	URL      string
+	Username string
+	Password string
+	Labels   map[string]string
+	Timeout  time.Duration
+	// BatchSize is the maximum number of samples per request.
+	BatchSize int
+	// QueueSize is the number of batches waiting to be sent before new ones
+	// are dropped.
+	QueueSize int
+	// MaxRetries bounds the retries of a batch on recoverable errors, which
+	// are spaced by MinBackoff doubling each time.
+	MaxRetries int
+	MinBackoff time.Duration
+}
+
+// remoteWriter periodically gathers metrics and sends them with the
+// Prometheus remote write protocol.
+type remoteWriter struct {
+	cfg      remoteWriteConfig
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	queue    chan []*rwTimeSeries
+
+	samplesSent, samplesFailed, samplesDropped, retries prometheus.Counter
+	queueDepth                                          prometheus.GaugeFunc
+}
+
+func newRemoteWriter(cfg remoteWriteConfig, gatherer prometheus.Gatherer) *remoteWriter {
+	w := &remoteWriter{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		gatherer: gatherer,
+		queue:    make(chan []*rwTimeSeries, cfg.QueueSize),
+		samplesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_remote_write_samples_sent_total",
+			Help:      "Number of samples successfully sent with remote write.",
+		}),
+		samplesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_remote_write_samples_failed_total",
+			Help:      "Number of samples that could not be sent with remote write.",
+		}),
+		samplesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_remote_write_samples_dropped_total",
+			Help:      "Number of samples dropped because the remote write queue was full.",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_remote_write_retries_total",
+			Help:      "Number of remote write requests retried.",
+		}),
+	}
+	w.queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_remote_write_queue_depth",
+		Help:      "Number of batches waiting to be sent with remote write.",
+	}, func() float64 { return float64(len(w.queue)) })
+	return w
+}
+
+// Describe implements prometheus.Collector.
+func (w *remoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.samplesSent.Desc()
+	ch <- w.samplesFailed.Desc()
+	ch <- w.samplesDropped.Desc()
+	ch <- w.retries.Desc()
+	ch <- w.queueDepth.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (w *remoteWriter) Collect(ch chan<- prometheus.Metric) {
+	ch <- w.samplesSent
+	ch <- w.samplesFailed
+	ch <- w.samplesDropped
+	ch <- w.retries
+	ch <- w.queueDepth
+}
+
+// collect gathers a snapshot and queues it in batches.
+func (w *remoteWriter) collect() {
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		log.Errorf("Error gathering metrics for remote write: %v", err)
+	}
+	series := toTimeSeries(mfs, w.cfg.Labels, time.Now())
+	for len(series) > 0 {
+		n := w.cfg.BatchSize
+		if n <= 0 || n > len(series) {
+			n = len(series)
+		}
+		select {
+		case w.queue <- series[:n]:
+		default:
+			w.samplesDropped.Add(float64(n))
+		}
+		series = series[n:]
+	}
+}
+
+// run collects at every interval until ctx is done, and then returns once
+// the queued batches are sent.
+func (w *remoteWriter) run(ctx context.Context, interval time.Duration) {
+	sent := make(chan struct{})
+	go func() {
+		for batch := range w.queue {
+			w.send(ctx, batch)
+		}
+		close(sent)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.collect()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			close(w.queue)
+			<-sent
+			return
+		}
+	}
+}
+
+// send sends a batch, retrying on network errors, throttling and server
+// errors until ctx is done.
+func (w *remoteWriter) send(ctx context.Context, batch []*rwTimeSeries) error {
+	data, err := proto.Marshal(&rwWriteRequest{Timeseries: batch})
+	if err != nil {
+		w.samplesFailed.Add(float64(len(batch)))
+		return err
+	}
+	body := snappy.Encode(nil, data)
+
+	backoff := w.cfg.MinBackoff
+	for try := 0; ; try++ {
+		recoverable, err := w.post(body)
+		if err == nil {
+			w.samplesSent.Add(float64(len(batch)))
+			return nil
+		}
+		if !recoverable || try >= w.cfg.MaxRetries {
+			log.Errorf("Unable to send %d samples with remote write: %v", len(batch), err)
+			w.samplesFailed.Add(float64(len(batch)))
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			log.Errorf("Unable to send %d samples with remote write before shutting down: %v", len(batch), err)
+			w.samplesFailed.Add(float64(len(batch)))
+			return err
+		}
+		w.retries.Inc()
+		backoff *= 2
+	}
+}
+
+func (w *remoteWriter) post(body []byte) (recoverable bool, err error) {
+	req, err := http.NewRequest("POST", w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "bunnycdn_exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.cfg.Username != "" {
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRemoteWrite(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		requests int
+		received rwWriteRequest
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		assertEqual(t, "snappy", r.Header.Get("Content-Encoding"), "Content-Encoding")
+		compressed, _ := ioutil.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Error("Unable to decompress request: ", err)
+		}
+		if err := proto.Unmarshal(data, &received); err != nil {
+			t.Error("Unable to decode request: ", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	h := newBunny([]byte(`[]`), []byte(`{"UserBalanceHistoryChart": {"2019-05-02T00:00:00": 1000}}`))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	exporter.chartTimestamps = true
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+
+	w := newRemoteWriter(remoteWriteConfig{
+		URL:        receiver.URL,
+		Labels:     map[string]string{"job": "bunnycdn"},
+		Timeout:    time.Second,
+		QueueSize:  10,
+		MaxRetries: 2,
+		MinBackoff: time.Millisecond,
+	}, reg)
+	w.collect()
+	close(w.queue)
+	for batch := range w.queue {
+		w.send(context.Background(), batch)
+	}
+
+	assertEqual(t, 2, requests, "Requests including the retried one")
+	assertEqual(t, 1.0, testutil.ToFloat64(w.retries), "Retries")
+	assertEqual(t, 0.0, testutil.ToFloat64(w.samplesFailed), "Failed samples")
+	assertEqual(t, float64(len(received.Timeseries)), testutil.ToFloat64(w.samplesSent), "Sent samples")
+
+	var balance *rwTimeSeries
+	for _, ts := range received.Timeseries {
+		if ts.Labels[0].Value == "bunnycdn_account_balance" {
+			balance = ts
+		}
+	}
+	if balance == nil {
+		t.Fatal("Account balance was not sent")
+	}
+	assertEqual(t, "job", balance.Labels[1].Name, "Extra label")
+	assertEqual(t, 1000.0, balance.Samples[0].Value, "Balance value")
+	assertEqual(t, int64(1556755200000), balance.Samples[0].Timestamp, "Balance timestamp from the chart")
+}
+
+func TestRemoteWriteDropsClientErrors(t *testing.T) {
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	w := newRemoteWriter(remoteWriteConfig{URL: receiver.URL, Timeout: time.Second, MaxRetries: 3}, prometheus.NewRegistry())
+	batch := []*rwTimeSeries{{
+		Labels:  []*rwLabel{{Name: "__name__", Value: "up"}},
+		Samples: []*rwSample{{Value: 1, Timestamp: 1}},
+	}}
+	if err := w.send(context.Background(), batch); err == nil {
+		t.Fatal("Expected an error sending a rejected batch")
+	}
+	assertEqual(t, 1, requests, "Client errors are not retried")
+	assertEqual(t, 1.0, testutil.ToFloat64(w.samplesFailed), "Failed samples")
+}
+
+func TestRemoteWriteFlushesOnShutdown(t *testing.T) {
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer receiver.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+	w := newRemoteWriter(remoteWriteConfig{URL: receiver.URL, Timeout: time.Second, QueueSize: 10}, reg)
+
+	// The collection made before noticing the shutdown is still sent.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.run(ctx, time.Hour)
+	assertEqual(t, 1, requests, "Requests sent before returning")
+	assertEqual(t, 1.0, testutil.ToFloat64(w.samplesSent), "Sent samples")
+}
+
+func TestRemoteWriteBackoffCancelled(t *testing.T) {
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "try again", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	w := newRemoteWriter(remoteWriteConfig{URL: receiver.URL, Timeout: time.Second, MaxRetries: 3, MinBackoff: time.Hour}, prometheus.NewRegistry())
+	batch := []*rwTimeSeries{{
+		Labels:  []*rwLabel{{Name: "__name__", Value: "up"}},
+		Samples: []*rwSample{{Value: 1, Timestamp: 1}},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.send(ctx, batch); err == nil {
+		t.Fatal("Expected an error sending a batch while shutting down")
+	}
+	assertEqual(t, 1, requests, "Requests before giving up")
+	assertEqual(t, 1.0, testutil.ToFloat64(w.samplesFailed), "Failed samples")
+}
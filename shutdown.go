@@ -0,0 +1,60 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// shutdownContext returns a context cancelled on SIGINT or SIGTERM. The
+// process exits once grace has elapsed from the signal, or on a second
+// signal, whether or not the shutdown completed.
+func shutdownContext(grace time.Duration) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	term := make(chan os.Signal, 2)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-term
+		log.Infof("Received %s, shutting down within %s", sig, grace)
+		cancel()
+		select {
+		case <-term:
+			log.Errorln("Received a second signal, exiting")
+		case <-time.After(grace):
+			log.Errorf("Shutdown did not complete within %s, exiting", grace)
+		}
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// shutdownGatherer gathers from g, failing if ctx is done by then, so that
+// outputs do not send the collections cancelled by the shutdown.
+func shutdownGatherer(ctx context.Context, g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return mfs, err
+	})
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestShutdownGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	g := shutdownGatherer(ctx, reg)
+
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(mfs), "Metrics gathered before the shutdown")
+
+	cancel()
+	mfs, err = g.Gather()
+	if err == nil {
+		t.Fatal("Expected an error gathering after the shutdown")
+	}
+	assertEqual(t, 0, len(mfs), "Metrics gathered after the shutdown")
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is bumped on incompatible changes of the snapshot document.
+const snapshotVersion = 1
+
+// snapshot is the document served on /api/v1/snapshot. It holds the data
+// decoded by the last scrape, so that tools do not have to parse metrics.
+type snapshot struct {
+	Version     int                `json:"version"`
+	CollectedAt time.Time          `json:"collected_at"`
+	Duration    float64            `json:"duration_seconds"`
+	Up          bool               `json:"up"`
+	Account     *snapshotAccount   `json:"account"`
+	PullZones   []snapshotPullZone `json:"pull_zones"`
+	Errors      []snapshotError    `json:"errors"`
+}
+
+type snapshotAccount struct {
+	Balance          snapshotSeries `json:"balance"`
+	StorageUsedBytes snapshotSeries `json:"storage_used_bytes"`
+}
+
+type snapshotPullZone struct {
+	ID                   int64              `json:"id"`
+	Name                 string             `json:"name"`
+	MonthlyCharges       float64            `json:"monthly_charges"`
+	BandwidthUsedBytes   snapshotSeries     `json:"bandwidth_used_bytes"`
+	BandwidthCachedBytes snapshotSeries     `json:"bandwidth_cached_bytes"`
+	CacheHitRate         snapshotSeries     `json:"cache_hit_rate"`
+	RequestsServed       snapshotSeries     `json:"requests_served"`
+	PullRequestsPulled   snapshotSeries     `json:"pull_requests_pulled"`
+	Errors3xx            snapshotSeries     `json:"errors_3xx"`
+	Errors4xx            snapshotSeries     `json:"errors_4xx"`
+	Errors5xx            snapshotSeries     `json:"errors_5xx"`
+	Geo                  []snapshotLocation `json:"geo"`
+}
+
+// snapshotSeries is a statistics chart. Value is the one exported as metric.
+type snapshotSeries struct {
+	Value  float64         `json:"value"`
+	Points []snapshotPoint `json:"points"`
+}
+
+type snapshotPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type snapshotLocation struct {
+	Region   string  `json:"region"`
+	Location string  `json:"location"`
+	Requests float64 `json:"requests"`
+}
+
+// snapshotError is a failed API call. PullZone is empty for calls not
+// specific to a pull zone.
+type snapshotError struct {
+	Call     string `json:"call"`
+	PullZone string `json:"pull_zone,omitempty"`
+	Error    string `json:"error"`
+}
+
+func newSnapshotSeries(chart map[string]float64) snapshotSeries {
+	// The value is that of the latest point, as for the metrics.
+	_, value := latestChartPoint(chart)
+	s := snapshotSeries{Value: value, Points: []snapshotPoint{}}
+	for k, v := range chart {
+		t, err := parseChartTime(k)
+		if err != nil {
+			continue
+		}
+		s.Points = append(s.Points, snapshotPoint{Time: t, Value: v})
+	}
+	sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+	return s
+}
+
+func newSnapshotAccount(stats *bunnyStatistics) *snapshotAccount {
+	return &snapshotAccount{
+		Balance:          newSnapshotSeries(stats.UserBalanceHistory),
+		StorageUsedBytes: newSnapshotSeries(stats.UserStorageUsed),
+	}
+}
+
+func newSnapshotPullZone(pz bunnyPullZone, stats *bunnyStatistics) snapshotPullZone {
+	s := snapshotPullZone{
+		ID:                   pz.ID,
+		Name:                 pz.Name,
+		MonthlyCharges:       pz.MonthlyCharges,
+		BandwidthUsedBytes:   newSnapshotSeries(stats.BandwidthUsed),
+		BandwidthCachedBytes: newSnapshotSeries(stats.BandwidthCached),
+		CacheHitRate:         newSnapshotSeries(stats.CacheHitRate),
+		RequestsServed:       newSnapshotSeries(stats.RequestsServed),
+		PullRequestsPulled:   newSnapshotSeries(stats.PullRequestsPulled),
+		Errors3xx:            newSnapshotSeries(stats.Error3Xx),
+		Errors4xx:            newSnapshotSeries(stats.Error4Xx),
+		Errors5xx:            newSnapshotSeries(stats.Error5Xx),
+		Geo:                  []snapshotLocation{},
+	}
+	for _, loc := range stats.trafficLocations() {
+		s.Geo = append(s.Geo, snapshotLocation{Region: loc.Region, Location: loc.Location, Requests: loc.Requests})
+	}
+	sort.Slice(s.Geo, func(i, j int) bool {
+		if s.Geo[i].Requests != s.Geo[j].Requests {
+			return s.Geo[i].Requests > s.Geo[j].Requests
+		}
+		return s.Geo[i].Location < s.Geo[j].Location
+	})
+	return s
+}
+
+func (s *snapshot) addError(call, pullZone string, err error) {
+	s.Errors = append(s.Errors, snapshotError{Call: call, PullZone: pullZone, Error: err.Error()})
+}
+
+// serveSnapshot serves the snapshot of the last scrape, or 503 before the
+// first one.
+func (e *Exporter) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	e.snapshotMtx.RLock()
+	s := e.snapshot
+	e.snapshotMtx.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if s == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"no collection yet"}` + "\n"))
+		return
+	}
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func getSnapshot(t *testing.T, e *Exporter) (int, snapshot) {
+	rec := httptest.NewRecorder()
+	e.serveSnapshot(rec, httptest.NewRequest("GET", "/api/v1/snapshot", nil))
+	var s snapshot
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+			t.Fatal("Unable to decode snapshot: ", err)
+		}
+	}
+	return rec.Code, s
+}
+
+func TestSnapshot(t *testing.T) {
+	h := newBunny([]byte(`[{"Id": 1, "Name": "zone"}]`), []byte(`{
+		"BandwidthUsedChart": {"2019-05-02T00:00:00Z": 100, "2019-05-01T00:00:00Z": 50},
+		"UserBalanceHistoryChart": {"2019-05-02T00:37:51": 1000},
+		"GeoTrafficDistribution": {"EU: London, GB": 10, "NA: Chicago, IL": 20}
+	}`))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+
+	code, _ := getSnapshot(t, exporter)
+	assertEqual(t, http.StatusServiceUnavailable, code, "Status before the first collection")
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+	reg.Gather()
+
+	code, s := getSnapshot(t, exporter)
+	assertEqual(t, http.StatusOK, code, "Status after a collection")
+	assertEqual(t, snapshotVersion, s.Version, "Snapshot version")
+	assertEqual(t, true, s.Up, "Up")
+	assertEqual(t, 0, len(s.Errors), "Errors")
+	assertEqual(t, 1000.0, s.Account.Balance.Value, "Account balance")
+	assertEqual(t, 1, len(s.PullZones), "Pull zones")
+
+	pz := s.PullZones[0]
+	assertEqual(t, "zone", pz.Name, "Pull zone name")
+	assertEqual(t, 2, len(pz.BandwidthUsedBytes.Points), "Bandwidth points")
+	assertEqual(t, 50.0, pz.BandwidthUsedBytes.Points[0].Value, "Bandwidth points are sorted by time")
+	assertEqual(t, 100.0, pz.BandwidthUsedBytes.Value, "Bandwidth value of the latest point")
+	assertEqual(t, "Chicago, IL", pz.Geo[0].Location, "Geo is sorted by requests")
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pullzone" {
+			w.Write([]byte(`[{"Id": 1, "Name": "zone"}]`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer h.Close()
+	exporter, _ := NewExporter(h.URL, "api_key", true, accountMetrics, pullZoneMetrics, time.Second, geoLimits{})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+	reg.Gather()
+
+	_, s := getSnapshot(t, exporter)
+	assertEqual(t, 0, len(s.PullZones), "Pull zones without statistics")
+	assertEqual(t, 2, len(s.Errors), "Errors")
+	assertEqual(t, "pull_zone_statistics", s.Errors[0].Call, "Failed call")
+	assertEqual(t, "zone", s.Errors[0].PullZone, "Pull zone of the failed call")
+	assertEqual(t, "account_statistics", s.Errors[1].Call, "Failed account call")
+	if s.Account != nil {
+		t.Fatal("Account should be missing without statistics")
+	}
+}
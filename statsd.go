@@ -0,0 +1,209 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// statsdMaxPacket keeps packets below the usual MTU so they are not
+// fragmented.
+const statsdMaxPacket = 1432
+
+var statsdReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", ":", "_", "\n", "_")
+
+// statsdSink periodically gathers metrics and sends them as StatsD metrics,
+// with the labels as DogStatsD tags. Gauges are sent as gauges; counters, and
+// the count and sum of histograms and summaries, as counts of their increase
+// since the previous flush.
+type statsdSink struct {
+	conn      io.Writer
+	gatherer  prometheus.Gatherer
+	prefix    string
+	dogstatsd bool
+
+	// last holds the previous value of counters.
+	last map[statsdName]float64
+
+	sent, errors prometheus.Counter
+}
+
+func newStatsdSink(conn io.Writer, gatherer prometheus.Gatherer, prefix string, dogstatsd bool) *statsdSink {
+	return &statsdSink{
+		conn:      conn,
+		gatherer:  gatherer,
+		prefix:    prefix,
+		dogstatsd: dogstatsd,
+		last:      map[statsdName]float64{},
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_statsd_metrics_sent_total",
+			Help:      "Number of metrics sent to StatsD.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_statsd_send_errors_total",
+			Help:      "Number of packets that could not be sent to StatsD.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (s *statsdSink) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.sent.Desc()
+	ch <- s.errors.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (s *statsdSink) Collect(ch chan<- prometheus.Metric) {
+	ch <- s.sent
+	ch <- s.errors
+}
+
+// statsdName is the name and DogStatsD tags of a metric.
+type statsdName struct {
+	name, tags string
+}
+
+// statsdName returns the StatsD name and tags of a metric. Without DogStatsD
+// tags, the label values are appended to the name instead.
+func (s *statsdSink) statsdName(name string, labels []*dto.LabelPair) statsdName {
+	n := statsdName{name: s.prefix + name}
+	if !s.dogstatsd {
+		for _, l := range labels {
+			n.name += "." + strings.Replace(statsdReplacer.Replace(l.GetValue()), ".", "_", -1)
+		}
+		return n
+	}
+
+	tags := make([]string, 0, len(labels))
+	for _, l := range labels {
+		tags = append(tags, l.GetName()+":"+statsdReplacer.Replace(l.GetValue()))
+	}
+	sort.Strings(tags)
+	n.tags = strings.Join(tags, ",")
+	return n
+}
+
+func (n statsdName) line(value float64, typ string) string {
+	l := n.name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ
+	if n.tags != "" {
+		l += "|#" + n.tags
+	}
+	return l
+}
+
+func (s *statsdSink) lines(mfs []*dto.MetricFamily) []string {
+	var lines []string
+	seen := map[statsdName]bool{}
+	// count sends the increase of a counter since the previous flush. The
+	// first value of a counter is only remembered.
+	count := func(n statsdName, value float64) {
+		seen[n] = true
+		last, ok := s.last[n]
+		s.last[n] = value
+		if !ok {
+			return
+		}
+		delta := value - last
+		if delta < 0 {
+			// The counter was reset.
+			delta = value
+		}
+		lines = append(lines, n.line(delta, "c"))
+	}
+
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name := s.statsdName(mf.GetName(), m.GetLabel())
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				lines = append(lines, name.line(m.GetGauge().GetValue(), "g"))
+			case dto.MetricType_UNTYPED:
+				lines = append(lines, name.line(m.GetUntyped().GetValue(), "g"))
+			case dto.MetricType_COUNTER:
+				count(name, m.GetCounter().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				count(s.statsdName(mf.GetName()+"_count", m.GetLabel()), float64(m.GetHistogram().GetSampleCount()))
+				count(s.statsdName(mf.GetName()+"_sum", m.GetLabel()), m.GetHistogram().GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				count(s.statsdName(mf.GetName()+"_count", m.GetLabel()), float64(m.GetSummary().GetSampleCount()))
+				count(s.statsdName(mf.GetName()+"_sum", m.GetLabel()), m.GetSummary().GetSampleSum())
+			}
+		}
+	}
+	// Forget counters that disappeared, so that the map does not grow forever.
+	for n := range s.last {
+		if !seen[n] {
+			delete(s.last, n)
+		}
+	}
+	return lines
+}
+
+func (s *statsdSink) flush() {
+	mfs, err := s.gatherer.Gather()
+	if err != nil {
+		log.Errorf("Error gathering metrics for StatsD: %v", err)
+	}
+	lines := s.lines(mfs)
+
+	var packet bytes.Buffer
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := s.conn.Write(packet.Bytes()); err != nil {
+			log.Debugf("Unable to send StatsD packet: %v", err)
+			s.errors.Inc()
+		}
+		packet.Reset()
+	}
+	for _, l := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(l) > statsdMaxPacket {
+			send()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(l)
+	}
+	send()
+	s.sent.Add(float64(len(lines)))
+}
+
+// run flushes at every interval until ctx is done. A flush in progress is
+// completed.
+func (s *statsdSink) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.flush()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStatsdLines(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bunnycdn_requests_served"}, []string{"pull_zone", "location"})
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "bunnycdn_log_requests_total"}, []string{"pull_zone"})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(gauge, counter)
+	gauge.WithLabelValues("zone", "London, GB").Set(10)
+	counter.WithLabelValues("zone").Add(5)
+
+	var out bytes.Buffer
+	s := newStatsdSink(&out, reg, "cdn.", true)
+	s.flush()
+	assertEqual(t, "cdn.bunnycdn_requests_served:10|g|#location:London_ GB,pull_zone:zone", out.String(), "First flush only has gauges")
+
+	counter.WithLabelValues("zone").Add(3)
+	out.Reset()
+	s.flush()
+	lines := strings.Split(out.String(), "\n")
+	assertEqual(t, 2, len(lines), "Lines of second flush")
+	assertEqual(t, "cdn.bunnycdn_log_requests_total:3|c|#pull_zone:zone", lines[0], "Counter increase")
+
+	s = newStatsdSink(&out, reg, "", false)
+	out.Reset()
+	s.flush()
+	assertEqual(t, "bunnycdn_requests_served.London_ GB.zone:10|g", out.String(), "Plain StatsD name")
+}
+
+func TestStatsdUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "value"}, []string{"id"})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(gauge)
+	for i := 0; i < 200; i++ {
+		gauge.WithLabelValues(strings.Repeat("x", i)).Set(1)
+	}
+	newStatsdSink(conn, reg, "", true).flush()
+
+	lines := 0
+	buf := make([]byte, 65536)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	for lines < 200 {
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Received %d lines: %v", lines, err)
+		}
+		if n > statsdMaxPacket {
+			t.Fatalf("Packet of %d bytes exceeds the maximum size", n)
+		}
+		lines += len(strings.Split(string(buf[:n]), "\n"))
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// secretFlag matches the names of flags whose value is not shown on the
+// status page.
+var secretFlag = regexp.MustCompile(`api-key|password|token|secret|header|webhook-url`)
+
+// urlUserinfo matches the user and password of URLs, such as those of
+// --push.gateway-url or --remote-write.url, which are not shown either.
+var urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#@\s]*@`)
+
+// statusFlag is a command line flag shown on the status page.
+type statusFlag struct {
+	Name, Value string
+}
+
+// statusPullZone is the collection history of a pull zone.
+type statusPullZone struct {
+	Name        string
+	Up          bool
+	LastSuccess time.Time
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// status keeps what the status page shows across scrapes.
+type status struct {
+	metricsPath string
+	flags       []statusFlag
+
+	mtx      sync.Mutex
+	accounts []*statusAccount
+}
+
+// statusAccount is the status of an account.
+type statusAccount struct {
+	name      string
+	exporter  *Exporter
+	last      *snapshot
+	pullZones map[string]*statusPullZone
+}
+
+func newStatus(metricsPath string, flags []*kingpin.FlagModel) *status {
+	s := &status{metricsPath: metricsPath}
+	for _, f := range flags {
+		if f.Name == "help" || f.Name == "version" {
+			continue
+		}
+		value := f.Value.String()
+		if value != "" && secretFlag.MatchString(f.Name) {
+			value = "<redacted>"
+		}
+		value = urlUserinfo.ReplaceAllString(value, "${1}<redacted>@")
+		s.flags = append(s.flags, statusFlag{Name: f.Name, Value: value})
+	}
+	sort.Slice(s.flags, func(i, j int) bool { return s.flags[i].Name < s.flags[j].Name })
+	return s
+}
+
+// addAccount shows an account on the page, and returns the function to call
+// with its snapshots.
+func (s *status) addAccount(name string, e *Exporter) func(*snapshot) {
+	a := &statusAccount{name: name, exporter: e, pullZones: map[string]*statusPullZone{}}
+	s.mtx.Lock()
+	s.accounts = append(s.accounts, a)
+	s.mtx.Unlock()
+	return func(snap *snapshot) { s.update(a, snap) }
+}
+
+// update records the outcome of a scrape.
+func (s *status) update(a *statusAccount, snap *snapshot) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	a.last = snap
+
+	for _, e := range snap.Errors {
+		// Pull zones are not known when they could not be listed.
+		if e.Call == "list_pull_zones" {
+			return
+		}
+	}
+	pullZones := map[string]*statusPullZone{}
+	get := func(name string) *statusPullZone {
+		pz, ok := a.pullZones[name]
+		if !ok {
+			pz = &statusPullZone{Name: name}
+		}
+		pullZones[name] = pz
+		return pz
+	}
+	for _, p := range snap.PullZones {
+		pz := get(p.Name)
+		pz.Up = true
+		pz.LastSuccess = snap.CollectedAt
+	}
+	for _, e := range snap.Errors {
+		if e.PullZone == "" {
+			continue
+		}
+		pz := get(e.PullZone)
+		pz.Up = false
+		pz.LastError = e.Error
+		pz.LastErrorAt = snap.CollectedAt
+	}
+	// Pull zones that were removed are forgotten.
+	a.pullZones = pullZones
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<html>
+<head><title>BunnyCDN Exporter</title></head>
+<body>
+<h1>BunnyCDN Exporter</h1>
+<p><a href="{{.MetricsPath}}">Metrics</a> - <a href="/api/v1/snapshot">Snapshot</a></p>
+{{range .Accounts}}
+{{if .Name}}<h2>Account {{.Name}}</h2>{{end}}
+<h3>Last collection</h3>
+{{with .Last}}
+<table>
+<tr><th align="left">Collected at</th><td>{{.CollectedAt.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th align="left">Duration</th><td>{{printf "%.3f" .Duration}}s</td></tr>
+<tr><th align="left">Up</th><td>{{.Up}}</td></tr>
+</table>
+{{range .Errors}}{{if not .PullZone}}<p>Error in {{.Call}}: {{.Error}}</p>{{end}}{{end}}
+{{else}}
+<p>No collection yet.</p>
+{{end}}
+<h3>Pull zones</h3>
+<table>
+<tr><th align="left">Name</th><th align="left">Status</th><th align="left">Last success</th><th align="left">Last error</th></tr>
+{{range .PullZones}}<tr>
+<td>{{.Name}}</td>
+<td>{{if .Up}}ok{{else}}failing{{end}}</td>
+<td>{{if not .LastSuccess.IsZero}}{{.LastSuccess.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+<td>{{if .LastError}}{{.LastErrorAt.Format "2006-01-02 15:04:05 MST"}}: {{.LastError}}{{end}}</td>
+</tr>{{end}}
+</table>
+<h3>API calls</h3>
+<table>
+<tr><th align="left">Scrapes</th><td>{{.Scrapes}}</td></tr>
+<tr><th align="left">API calls</th><td>{{.APICalls}}</td></tr>
+<tr><th align="left">Errors</th><td>{{.Errors}}</td></tr>
+</table>
+{{end}}
+<h2>Configuration</h2>
+<table>
+{{range .Flags}}<tr><th align="left">{{.Name}}</th><td>{{.Value}}</td></tr>{{end}}
+</table>
+<h2>Version</h2>
+<p>{{.Version}}</p>
+<p>{{.BuildContext}}</p>
+</body>
+</html>
+`))
+
+type statusAccountData struct {
+	Name                      string
+	Last                      *snapshot
+	PullZones                 []statusPullZone
+	Scrapes, APICalls, Errors float64
+}
+
+// ServeHTTP serves the status page.
+func (s *status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		MetricsPath           string
+		Accounts              []statusAccountData
+		Flags                 []statusFlag
+		Version, BuildContext string
+	}{
+		MetricsPath:  s.metricsPath,
+		Flags:        s.flags,
+		Version:      version.Info(),
+		BuildContext: version.BuildContext(),
+	}
+	s.mtx.Lock()
+	for _, a := range s.accounts {
+		d := statusAccountData{
+			Name:     a.name,
+			Last:     a.last,
+			Scrapes:  counterValue(a.exporter.totalScrapes),
+			APICalls: counterValue(a.exporter.totalAPICalls),
+			Errors:   counterValue(a.exporter.totalErrors),
+		}
+		for _, pz := range a.pullZones {
+			d.PullZones = append(d.PullZones, *pz)
+		}
+		sort.Slice(d.PullZones, func(i, j int) bool { return d.PullZones[i].Name < d.PullZones[j].Name })
+		data.Accounts = append(data.Accounts, d)
+	}
+	s.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, data); err != nil {
+		log.Errorf("Error rendering status page: %v", err)
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// syslogMaxMessageSize bounds the size of a single message read over TCP.
+	syslogMaxMessageSize = 64 * 1024
+	// syslogIdleTimeout closes TCP connections without messages for that long.
+	syslogIdleTimeout = 5 * time.Minute
+)
+
+// syslogMessage is the part of a syslog message the exporter cares about.
+type syslogMessage struct {
+	Hostname string
+	AppName  string
+	Message  string
+}
+
+// parseSyslog parses a message in either RFC5424 or RFC3164 format.
+func parseSyslog(b []byte) (syslogMessage, error) {
+	s := string(bytes.TrimRight(b, "\r\n\x00"))
+	if !strings.HasPrefix(s, "<") {
+		return syslogMessage{}, errors.New("missing priority")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return syslogMessage{}, errors.New("invalid priority")
+	}
+	if _, err := strconv.Atoi(s[1:end]); err != nil {
+		return syslogMessage{}, fmt.Errorf("invalid priority: %v", err)
+	}
+	s = s[end+1:]
+
+	if strings.HasPrefix(s, "1 ") {
+		return parseRFC5424(s[2:])
+	}
+	return parseRFC3164(s), nil
+}
+
+// parseRFC5424 parses what follows "<PRI>1 ", that is
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func parseRFC5424(s string) (syslogMessage, error) {
+	fields := make([]string, 0, 5)
+	for len(fields) < 5 {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			return syslogMessage{}, errors.New("truncated header")
+		}
+		fields = append(fields, s[:i])
+		s = s[i+1:]
+	}
+
+	sd, err := structuredDataLength(s)
+	if err != nil {
+		return syslogMessage{}, err
+	}
+	msg := strings.TrimPrefix(strings.TrimPrefix(s[sd:], " "), "\ufeff")
+
+	m := syslogMessage{Message: msg}
+	if fields[1] != "-" {
+		m.Hostname = fields[1]
+	}
+	if fields[2] != "-" {
+		m.AppName = fields[2]
+	}
+	return m, nil
+}
+
+// structuredDataLength returns the length of the STRUCTURED-DATA at the
+// start of s, which is either "-" or a sequence of bracketed elements whose
+// quoted parameter values may contain escaped characters.
+func structuredDataLength(s string) (int, error) {
+	if strings.HasPrefix(s, "-") {
+		return 1, nil
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		quoted := false
+		for i++; ; i++ {
+			if i >= len(s) {
+				return 0, errors.New("unterminated structured data")
+			}
+			if quoted && s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				quoted = !quoted
+			}
+			if !quoted && s[i] == ']' {
+				i++
+				break
+			}
+		}
+	}
+	if i == 0 {
+		return 0, errors.New("invalid structured data")
+	}
+	return i, nil
+}
+
+// parseRFC3164 parses what follows "<PRI>", that is
+// [TIMESTAMP HOSTNAME] [TAG:] MSG. Everything is optional in practice, so
+// it never fails.
+func parseRFC3164(s string) syslogMessage {
+	var m syslogMessage
+	if len(s) > len(time.Stamp) && s[len(time.Stamp)] == ' ' {
+		if _, err := time.Parse(time.Stamp, s[:len(time.Stamp)]); err == nil {
+			s = s[len(time.Stamp)+1:]
+			if i := strings.IndexByte(s, ' '); i >= 0 {
+				m.Hostname = s[:i]
+				s = s[i+1:]
+			}
+		}
+	}
+	if i := strings.IndexByte(s, ' '); i > 0 && s[i-1] == ':' && !strings.ContainsRune(s[:i], '|') {
+		m.AppName = strings.TrimSuffix(s[:i-1], "]")
+		if j := strings.IndexByte(m.AppName, '['); j >= 0 {
+			m.AppName = m.AppName[:j]
+		}
+		s = s[i+1:]
+	}
+	m.Message = s
+	return m
+}
+
+type syslogPacket struct {
+	source string
+	data   []byte
+}
+
+// syslogAccount routes the log lines of the pull zones of an account, which
+// names resolves, to its metrics.
+type syslogAccount struct {
+	metrics *logMetrics
+	names   func(id int64) (string, bool)
+}
+
+// syslogOtherSource is the source label of the messages of senders beyond the
+// maximum number of sources.
+const syslogOtherSource = "other"
+
+// syslogSources bounds the cardinality of the source label: the first max
+// senders get their own label value, and the others share syslogOtherSource.
+type syslogSources struct {
+	mtx  sync.Mutex
+	max  int
+	seen map[string]struct{}
+}
+
+func (s *syslogSources) label(source string) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.seen[source]; ok {
+		return source
+	}
+	if len(s.seen) >= s.max {
+		return syslogOtherSource
+	}
+	s.seen[source] = struct{}{}
+	return source
+}
+
+// syslogReceiver accepts BunnyCDN access logs forwarded over syslog and feeds
+// them to the logMetrics of the account of their pull zone. Anyone can send
+// messages, so lines of pull zones that are not in any account are dropped
+// and the number of distinct senders in labels is bounded.
+type syslogReceiver struct {
+	accounts []syslogAccount
+	queue    chan syslogPacket
+	sources  *syslogSources
+
+	received, dropped, parseErrors, unknownZones *prometheus.CounterVec
+}
+
+func newSyslogReceiver(accounts []syslogAccount, queueSize, maxSources int) *syslogReceiver {
+	return &syslogReceiver{
+		accounts: accounts,
+		queue:    make(chan syslogPacket, queueSize),
+		sources:  &syslogSources{max: maxSources, seen: map[string]struct{}{}},
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "syslog_messages_total",
+			Help:      "Number of syslog messages received.",
+		}, []string{"source"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "syslog_messages_dropped_total",
+			Help:      "Number of syslog messages dropped because the processing queue was full.",
+		}, []string{"source"}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "syslog_parse_errors_total",
+			Help:      "Number of syslog messages that could not be parsed as access log lines.",
+		}, []string{"source"}),
+		unknownZones: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "syslog_unknown_pull_zone_total",
+			Help:      "Number of access log lines dropped because their pull zone is not in any account.",
+		}, []string{"source"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (r *syslogReceiver) Describe(ch chan<- *prometheus.Desc) {
+	r.received.Describe(ch)
+	r.dropped.Describe(ch)
+	r.parseErrors.Describe(ch)
+	r.unknownZones.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *syslogReceiver) Collect(ch chan<- prometheus.Metric) {
+	r.received.Collect(ch)
+	r.dropped.Collect(ch)
+	r.parseErrors.Collect(ch)
+	r.unknownZones.Collect(ch)
+}
+
+func (r *syslogReceiver) enqueue(source string, data []byte) {
+	label := r.sources.label(source)
+	r.received.WithLabelValues(label).Inc()
+	select {
+	case r.queue <- syslogPacket{source: source, data: data}:
+	default:
+		r.dropped.WithLabelValues(label).Inc()
+	}
+}
+
+// process handles queued messages until the queue is closed.
+func (r *syslogReceiver) process() {
+	for p := range r.queue {
+		m, err := parseSyslog(p.data)
+		if err != nil {
+			log.Debugf("Invalid syslog message from %s: %v", p.source, err)
+			r.parseErrors.WithLabelValues(r.sources.label(p.source)).Inc()
+			continue
+		}
+		l, err := parseLogLine(m.Message)
+		if err != nil {
+			log.Debugf("Invalid log line from %s: %v", p.source, err)
+			r.parseErrors.WithLabelValues(r.sources.label(p.source)).Inc()
+			continue
+		}
+		if !r.route(l) {
+			log.Debugf("Log line of unknown pull zone %d from %s", l.PullZoneID, p.source)
+			r.unknownZones.WithLabelValues(r.sources.label(p.source)).Inc()
+		}
+	}
+}
+
+// route observes a log line in the metrics of the account of its pull zone,
+// and tells whether there is one.
+func (r *syslogReceiver) route(l bunnyLogLine) bool {
+	for _, a := range r.accounts {
+		if name, ok := a.names(l.PullZoneID); ok {
+			a.metrics.observe(name, l)
+			return true
+		}
+	}
+	return false
+}
+
+// serveUDP reads one message per datagram from conn until it is closed.
+func (r *syslogReceiver) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, syslogMaxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		r.enqueue(hostOf(addr), data)
+	}
+}
+
+// serveTCP accepts connections from l until it is closed.
+func (r *syslogReceiver) serveTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go r.handleConn(conn)
+	}
+}
+
+// handleConn reads messages framed with either octet counting or trailing
+// newlines (RFC6587) from a TCP connection.
+func (r *syslogReceiver) handleConn(conn net.Conn) {
+	defer conn.Close()
+	source := hostOf(conn.RemoteAddr())
+	br := bufio.NewReaderSize(conn, syslogMaxMessageSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(syslogIdleTimeout))
+		data, err := readSyslogFrame(br)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Debugf("Closing idle syslog connection from %s", source)
+				return
+			}
+			if err != io.EOF {
+				log.Debugf("Closing syslog connection from %s: %v", source, err)
+				r.parseErrors.WithLabelValues(r.sources.label(source)).Inc()
+			}
+			return
+		}
+		if len(data) > 0 {
+			r.enqueue(source, data)
+		}
+	}
+}
+
+func readSyslogFrame(br *bufio.Reader) ([]byte, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, errors.New("message too large")
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		return append([]byte(nil), bytes.TrimRight(line, "\r\n")...), nil
+	}
+
+	size, err := br.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+	if err != nil || n > syslogMaxMessageSize {
+		return nil, fmt.Errorf("invalid message length %q", size)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// pullZoneNames resolves the pull zone IDs found in log lines to the value
+// of their pull_zone label. IDs of pull zones excluded by the filters are
+// unknown. The list is fetched again at most once per refresh interval, when
+// a line shows up, so that forged IDs cannot flood the API and reloaded
+// labels are used.
+type pullZoneNames struct {
+	pullZones func() ([]bunnyPullZone, error)
+	refresh   time.Duration
+
+	mtx     sync.Mutex
+	names   map[int64]string
+	updated time.Time
+}
+
+func (n *pullZoneNames) lookup(id int64) (string, bool) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if time.Since(n.updated) >= n.refresh {
+		n.updated = time.Now()
+		pullZones, err := n.pullZones()
+		if err != nil {
+			log.Errorf("Unable to list pull zones for log lines: %v", err)
+		} else {
+			n.names = make(map[int64]string, len(pullZones))
+			for _, pz := range pullZones {
+				n.names[pz.ID] = pz.Name
+			}
+		}
+	}
+	name, ok := n.names[id]
+	return name, ok
+}
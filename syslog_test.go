@@ -0,0 +1,153 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseSyslog(t *testing.T) {
+	line := testLogHit[:len(testLogHit)-1]
+
+	m, err := parseSyslog([]byte(`<134>1 2019-05-02T00:00:00.000Z edge-de bunnycdn - - [meta zone="a \"b\" ]c"] ` + line))
+	if err != nil {
+		t.Fatal("Unexpected error parsing RFC5424 message: ", err)
+	}
+	assertEqual(t, "edge-de", m.Hostname, "RFC5424 hostname")
+	assertEqual(t, "bunnycdn", m.AppName, "RFC5424 app name")
+	assertEqual(t, line, m.Message, "RFC5424 message")
+
+	m, err = parseSyslog([]byte("<134>May  2 00:00:00 edge-de bunnycdn[12]: " + line + "\n"))
+	if err != nil {
+		t.Fatal("Unexpected error parsing RFC3164 message: ", err)
+	}
+	assertEqual(t, "edge-de", m.Hostname, "RFC3164 hostname")
+	assertEqual(t, "bunnycdn", m.AppName, "RFC3164 app name")
+	assertEqual(t, line, m.Message, "RFC3164 message")
+
+	if _, err := parseSyslog([]byte(line)); err == nil {
+		t.Fatal("Expected an error parsing a message without priority")
+	}
+}
+
+func waitFor(t *testing.T, c prometheus.Collector, want float64, message string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(c) != want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertEqual(t, want, testutil.ToFloat64(c), message)
+}
+
+func TestSyslogReceiver(t *testing.T) {
+	metrics := newLogMetrics()
+	r := newSyslogReceiver([]syslogAccount{{metrics: metrics, names: func(id int64) (string, bool) { return fmt.Sprintf("zone%d", id), id == 12345 }}}, 16, 10)
+	go r.process()
+	defer close(r.queue)
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	go r.serveUDP(udp)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	go r.serveTCP(tcp)
+
+	conn, err := net.Dial("udp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "<134>1 2019-05-02T00:00:00Z edge-de bunnycdn - - - "+testLogHit)
+	fmt.Fprint(conn, "<134>not a log line")
+	conn.Close()
+
+	conn, err = net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "<134>1 2019-05-02T00:00:00Z edge-de bunnycdn - - - " + testLogMiss[:len(testLogMiss)-1]
+	fmt.Fprintf(conn, "%d %s", len(msg), msg)
+	fmt.Fprint(conn, "<134>May  2 00:00:00 edge-de bunnycdn: "+testLogHit)
+	conn.Close()
+
+	waitFor(t, metrics.requests.WithLabelValues("zone12345", "200", "HIT"), 2, "Hits received")
+	waitFor(t, metrics.requests.WithLabelValues("zone12345", "404", "MISS"), 1, "Misses received")
+	waitFor(t, r.parseErrors.WithLabelValues("127.0.0.1"), 1, "Parse errors")
+	assertEqual(t, float64(4), testutil.ToFloat64(r.received.WithLabelValues("127.0.0.1")), "Messages received")
+	assertEqual(t, float64(0), testutil.ToFloat64(r.dropped.WithLabelValues("127.0.0.1")), "Messages dropped")
+}
+
+func TestSyslogForgedLines(t *testing.T) {
+	metrics := newLogMetrics()
+	r := newSyslogReceiver([]syslogAccount{{metrics: metrics, names: func(id int64) (string, bool) { return "zone", id == 12345 }}}, 16, 10)
+	go r.process()
+	defer close(r.queue)
+
+	header := "<134>1 2019-05-02T00:00:00Z edge-de bunnycdn - - - "
+	r.enqueue("127.0.0.1", []byte(header+"\xff|200|1556755200000|1024|12345|1.2.3.4|-|https://zone.b-cdn.net/\xff|DE|Mozilla/5.0"))
+	r.enqueue("127.0.0.1", []byte(header+"HIT|200|1556755200000|1024|99999|1.2.3.4|-|https://zone.b-cdn.net/|DE|Mozilla/5.0"))
+	r.enqueue("127.0.0.1", []byte(header+"HIT|99999|1556755200000|1024|12345|1.2.3.4|-|https://zone.b-cdn.net/|DE|Mozilla/5.0"))
+
+	waitFor(t, metrics.requests.WithLabelValues("zone", "200", "OTHER"), 1, "Lines with an unknown cache status")
+	waitFor(t, r.unknownZones.WithLabelValues("127.0.0.1"), 1, "Lines of unknown pull zones")
+	waitFor(t, r.parseErrors.WithLabelValues("127.0.0.1"), 1, "Lines with an invalid status")
+}
+
+func TestSyslogAccounts(t *testing.T) {
+	first, second := newLogMetrics(), newLogMetrics()
+	r := newSyslogReceiver([]syslogAccount{
+		{metrics: first, names: func(id int64) (string, bool) { return "first", id == 1 }},
+		{metrics: second, names: func(id int64) (string, bool) { return "second", id == 12345 }},
+	}, 16, 10)
+	go r.process()
+	defer close(r.queue)
+
+	r.enqueue("127.0.0.1", []byte("<134>1 2019-05-02T00:00:00Z edge-de bunnycdn - - - "+testLogHit))
+	waitFor(t, second.requests.WithLabelValues("second", "200", "HIT"), 1, "Line routed to the account of its pull zone")
+	ch := make(chan prometheus.Metric, 1)
+	first.requests.Collect(ch)
+	close(ch)
+	assertEqual(t, 0, len(ch), "Lines of the other account")
+}
+
+func TestSyslogMaxSources(t *testing.T) {
+	r := newSyslogReceiver(nil, 1, 2)
+
+	r.enqueue("10.0.0.1", []byte("garbage"))
+	r.enqueue("10.0.0.2", []byte("garbage"))
+	r.enqueue("10.0.0.3", []byte("garbage"))
+	r.enqueue("10.0.0.1", []byte("garbage"))
+
+	assertEqual(t, float64(2), testutil.ToFloat64(r.received.WithLabelValues("10.0.0.1")), "Messages of the first source")
+	assertEqual(t, float64(1), testutil.ToFloat64(r.dropped.WithLabelValues("10.0.0.1")), "Messages dropped of the first source")
+	assertEqual(t, float64(1), testutil.ToFloat64(r.dropped.WithLabelValues("10.0.0.2")), "Messages dropped of the second source")
+	assertEqual(t, float64(1), testutil.ToFloat64(r.received.WithLabelValues("other")), "Messages of sources beyond the maximum")
+	assertEqual(t, float64(1), testutil.ToFloat64(r.dropped.WithLabelValues("other")), "Messages dropped of sources beyond the maximum")
+
+	ch := make(chan prometheus.Metric, 16)
+	r.received.Collect(ch)
+	close(ch)
+	assertEqual(t, 3, len(ch), "Received series")
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	topDimensionPath     = "path"
+	topDimensionReferrer = "referrer"
+	topDimensionCountry  = "country"
+)
+
+var topDimensions = []string{topDimensionPath, topDimensionReferrer, topDimensionCountry}
+
+// topEntry is a value tracked by a spaceSaving sketch. Requests overestimates
+// the real count by at most Error. Bytes only accounts for the requests seen
+// since the value was last (re)inserted in the sketch.
+type topEntry struct {
+	Value    string  `json:"value"`
+	Requests float64 `json:"requests"`
+	Error    float64 `json:"error"`
+	Bytes    float64 `json:"bytes"`
+
+	index int
+}
+
+// topHeap is a min-heap of entries ordered by number of requests.
+type topHeap []*topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].Requests < h[j].Requests }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *topHeap) Push(x interface{}) {
+	e := x.(*topEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// spaceSaving implements the Space-Saving algorithm (Metwally et al.), which
+// finds the most frequent values of a stream while tracking at most capacity
+// of them.
+type spaceSaving struct {
+	capacity int
+	entries  map[string]*topEntry
+	heap     topHeap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, entries: make(map[string]*topEntry, capacity)}
+}
+
+func (s *spaceSaving) add(value string, bytes float64) {
+	if e, ok := s.entries[value]; ok {
+		e.Requests++
+		e.Bytes += bytes
+		heap.Fix(&s.heap, e.index)
+		return
+	}
+	if len(s.entries) < s.capacity {
+		e := &topEntry{Value: value, Requests: 1, Bytes: bytes}
+		s.entries[value] = e
+		heap.Push(&s.heap, e)
+		return
+	}
+	// Evict the least frequent value; the newcomer inherits its count.
+	e := s.heap[0]
+	delete(s.entries, e.Value)
+	e.Value = value
+	e.Error = e.Requests
+	e.Requests++
+	e.Bytes = bytes
+	s.entries[value] = e
+	heap.Fix(&s.heap, 0)
+}
+
+// top returns up to n entries by descending number of requests, or all of
+// them if n is zero.
+func (s *spaceSaving) top(n int) []topEntry {
+	entries := make([]topEntry, 0, len(s.heap))
+	for _, e := range s.heap {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Requests != entries[j].Requests {
+			return entries[i].Requests > entries[j].Requests
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// heavyHitters tracks the most requested paths, referrers and countries of
+// each pull zone from access log lines. Memory and the number of exported
+// series are bounded by the capacity of the sketches, whatever the traffic.
+// Sketches are reset every window so that they reflect recent traffic.
+type heavyHitters struct {
+	capacity int
+	exported int
+	window   time.Duration
+	now      func() time.Time
+
+	mtx      sync.Mutex
+	start    time.Time
+	sketches map[string]map[string]*spaceSaving
+
+	requestsDesc, bytesDesc *prometheus.Desc
+}
+
+func newHeavyHitters(capacity, exported int, window time.Duration) *heavyHitters {
+	return &heavyHitters{
+		capacity:     capacity,
+		exported:     exported,
+		window:       window,
+		now:          time.Now,
+		start:        time.Now(),
+		sketches:     map[string]map[string]*spaceSaving{},
+		requestsDesc: newMetric("log_top_requests", "Estimated requests of the most requested values in the current window.", []string{"pull_zone", "dimension", "value"}, nil),
+		bytesDesc:    newMetric("log_top_bytes_sent", "Bytes sent for the most requested values in the current window.", []string{"pull_zone", "dimension", "value"}, nil),
+	}
+}
+
+// rotate resets the sketches once the window is over. It must be called with
+// the mutex held.
+func (h *heavyHitters) rotate() {
+	now := h.now()
+	if h.window > 0 && now.Sub(h.start) >= h.window {
+		h.start = now
+		h.sketches = map[string]map[string]*spaceSaving{}
+	}
+}
+
+func (h *heavyHitters) observe(pullZone string, l bunnyLogLine) {
+	values := map[string]string{
+		topDimensionPath:     l.URL,
+		topDimensionReferrer: l.Referer,
+		topDimensionCountry:  l.Country,
+	}
+	// Paths are kept escaped, as decoding them may give invalid UTF-8.
+	if u, err := url.Parse(l.URL); err == nil {
+		values[topDimensionPath] = u.EscapedPath()
+	}
+	if u, err := url.Parse(l.Referer); err == nil && u.Host != "" {
+		values[topDimensionReferrer] = u.Host + u.EscapedPath()
+	}
+	for d, v := range values {
+		values[d] = validUTF8(v)
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.rotate()
+
+	sketches, ok := h.sketches[pullZone]
+	if !ok {
+		sketches = make(map[string]*spaceSaving, len(topDimensions))
+		for _, d := range topDimensions {
+			sketches[d] = newSpaceSaving(h.capacity)
+		}
+		h.sketches[pullZone] = sketches
+	}
+	for d, v := range values {
+		if v == "" || v == "-" {
+			continue
+		}
+		sketches[d].add(v, l.BytesSent)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (h *heavyHitters) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.requestsDesc
+	ch <- h.bytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (h *heavyHitters) Collect(ch chan<- prometheus.Metric) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.rotate()
+
+	for pullZone, sketches := range h.sketches {
+		for d, s := range sketches {
+			for _, e := range s.top(h.exported) {
+				requests, err := prometheus.NewConstMetric(h.requestsDesc, prometheus.GaugeValue, e.Requests, pullZone, d, e.Value)
+				if err != nil {
+					log.Debugf("Skipping top %s %q of pull zone %s: %v", d, e.Value, pullZone, err)
+					continue
+				}
+				ch <- requests
+				ch <- prometheus.MustNewConstMetric(h.bytesDesc, prometheus.GaugeValue, e.Bytes, pullZone, d, e.Value)
+			}
+		}
+	}
+}
+
+// ServeHTTP returns every tracked value as JSON, ranked by pull zone and
+// dimension.
+func (h *heavyHitters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mtx.Lock()
+	h.rotate()
+	resp := struct {
+		WindowStart time.Time                        `json:"window_start"`
+		PullZones   map[string]map[string][]topEntry `json:"pull_zones"`
+	}{
+		WindowStart: h.start,
+		PullZones:   make(map[string]map[string][]topEntry, len(h.sketches)),
+	}
+	for pullZone, sketches := range h.sketches {
+		resp.PullZones[pullZone] = make(map[string][]topEntry, len(sketches))
+		for d, s := range sketches {
+			resp.PullZones[pullZone][d] = s.top(0)
+		}
+	}
+	h.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := newSpaceSaving(10)
+	for i := 0; i < 100; i++ {
+		s.add("/hot", 10)
+		if i%2 == 0 {
+			s.add("/warm", 10)
+		}
+		s.add(fmt.Sprintf("/cold/%d", i), 10)
+	}
+
+	if len(s.entries) != 10 {
+		t.Fatal("Number of tracked values: expected: 10, got: ", len(s.entries))
+	}
+	top := s.top(2)
+	if len(top) != 2 {
+		t.Fatal("Number of top values: expected: 2, got: ", len(top))
+	}
+	assertEqual(t, "/hot", top[0].Value, "Most requested value")
+	assertEqual(t, float64(100), top[0].Requests, "Requests of most requested value")
+	assertEqual(t, float64(0), top[0].Error, "Error of most requested value")
+	assertEqual(t, "/warm", top[1].Value, "Second most requested value")
+}
+
+func TestHeavyHitters(t *testing.T) {
+	now := time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)
+	h := newHeavyHitters(10, 1, time.Hour)
+	h.now = func() time.Time { return now }
+	h.start = now
+
+	for _, line := range []string{testLogHit, testLogHit, testLogMiss} {
+		l, err := parseLogLine(line[:len(line)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.observe("pullzonename", l)
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	h.Collect(ch)
+	close(ch)
+	// One value is exported for each of path and country, with both requests
+	// and bytes; the referrer is always "-".
+	assertEqual(t, 4, len(ch), "Number of exported series")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/top", nil))
+	var resp struct {
+		PullZones map[string]map[string][]topEntry `json:"pull_zones"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal("Unexpected error decoding top values: ", err)
+	}
+	paths := resp.PullZones["pullzonename"][topDimensionPath]
+	if len(paths) != 2 {
+		t.Fatal("Number of paths: expected: 2, got: ", len(paths))
+	}
+	assertEqual(t, "/a.js", paths[0].Value, "Most requested path")
+	assertEqual(t, float64(2048), paths[0].Bytes, "Bytes sent for most requested path")
+
+	now = now.Add(time.Hour)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/top", nil))
+	resp.PullZones = nil
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	assertEqual(t, 0, len(resp.PullZones), "Number of pull zones after window")
+}
+
+func TestHeavyHittersInvalidUTF8(t *testing.T) {
+	h := newHeavyHitters(10, 10, 0)
+	h.observe("pullzonename", bunnyLogLine{URL: "https://pullzonename.b-cdn.net/%ff", Referer: "https://example.com/%fe", Country: "\xff"})
+	// Values of sketches filled some other way are skipped rather than
+	// failing the whole collection.
+	h.sketches["pullzonename"][topDimensionCountry].add("\xfe", 0)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(h)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error gathering metrics: ", err)
+	}
+	values := map[string]bool{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "value" {
+					values[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	assertEqual(t, true, values["/%ff"], "Path is kept escaped")
+	assertEqual(t, true, values["example.com/%fe"], "Referrer is kept escaped")
+	assertEqual(t, true, values["\ufffd"], "Invalid country is replaced")
+	assertEqual(t, 3, len(values), "Number of exported values")
+}
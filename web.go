@@ -0,0 +1,276 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// webConfig is the web configuration file, in the format of the Prometheus
+// exporter toolkit.
+type webConfig struct {
+	TLSConfig  webTLSConfig      `yaml:"tls_server_config"`
+	HTTPConfig webHTTPConfig     `yaml:"http_server_config"`
+	Users      map[string]string `yaml:"basic_auth_users"`
+
+	// dir is the directory of the file, to which paths are relative.
+	dir string
+	// authCache holds the result of checked passwords, as bcrypt is slow
+	// on purpose.
+	authMtx   sync.Mutex
+	authCache map[[sha256.Size]byte]bool
+}
+
+type webTLSConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuth               string   `yaml:"client_auth_type"`
+	ClientCAs                string   `yaml:"client_ca_file"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+type webHTTPConfig struct {
+	HTTP2   bool              `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+	tlsClientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+	tlsCipherSuites = map[string]uint16{
+		"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		"TLS_AES_128_GCM_SHA256":                        tls.TLS_AES_128_GCM_SHA256,
+		"TLS_AES_256_GCM_SHA384":                        tls.TLS_AES_256_GCM_SHA384,
+		"TLS_CHACHA20_POLY1305_SHA256":                  tls.TLS_CHACHA20_POLY1305_SHA256,
+	}
+	tlsCurves = map[string]tls.CurveID{
+		"CurveP256": tls.CurveP256,
+		"CurveP384": tls.CurveP384,
+		"CurveP521": tls.CurveP521,
+		"X25519":    tls.X25519,
+	}
+)
+
+// loadWebConfig reads a web configuration file. Unknown fields are errors,
+// so that misspelled settings are not silently ignored.
+func loadWebConfig(path string) (*webConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &webConfig{
+		HTTPConfig: webHTTPConfig{HTTP2: true},
+		dir:        filepath.Dir(path),
+		authCache:  map[[sha256.Size]byte]bool{},
+	}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, fmt.Errorf("invalid web configuration %s: %v", path, err)
+	}
+	if _, err := c.tlsConfig(); err != nil {
+		return nil, fmt.Errorf("invalid web configuration %s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *webConfig) path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.dir, p)
+}
+
+// tlsConfig returns the TLS configuration of the server, or nil if it does
+// not serve TLS.
+func (c *webConfig) tlsConfig() (*tls.Config, error) {
+	t := c.TLSConfig
+	if t.CertFile == "" && t.KeyFile == "" {
+		if t.ClientCAs != "" || t.ClientAuth != "" {
+			return nil, errors.New("client authentication requires a server certificate")
+		}
+		return nil, nil
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("both cert_file and key_file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(c.path(t.CertFile), c.path(t.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load server certificate: %v", err)
+	}
+	cfg := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: t.PreferServerCipherSuites,
+	}
+
+	var ok bool
+	if cfg.ClientAuth, ok = tlsClientAuthTypes[t.ClientAuth]; !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %q", t.ClientAuth)
+	}
+	if t.ClientCAs != "" {
+		pem, err := ioutil.ReadFile(c.path(t.ClientCAs))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA %s", t.ClientCAs)
+		}
+	} else if cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s requires client_ca_file", t.ClientAuth)
+	}
+	if t.MinVersion != "" {
+		if cfg.MinVersion, ok = tlsVersions[t.MinVersion]; !ok {
+			return nil, fmt.Errorf("unknown min_version %q", t.MinVersion)
+		}
+	}
+	if t.MaxVersion != "" {
+		if cfg.MaxVersion, ok = tlsVersions[t.MaxVersion]; !ok {
+			return nil, fmt.Errorf("unknown max_version %q", t.MaxVersion)
+		}
+	}
+	for _, name := range t.CipherSuites {
+		id, ok := tlsCipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	for _, name := range t.CurvePreferences {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+	if !c.HTTPConfig.HTTP2 {
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+	return cfg, nil
+}
+
+// dummyHash is compared against for unknown users, so that they cannot be
+// told apart by the response time.
+var dummyHash = []byte("$2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi")
+
+func (c *webConfig) authenticate(user, password string) bool {
+	key := sha256.Sum256([]byte(user + "\x00" + password))
+	c.authMtx.Lock()
+	ok, cached := c.authCache[key]
+	c.authMtx.Unlock()
+	if cached {
+		return ok
+	}
+
+	hash, known := c.Users[user]
+	if !known {
+		hash = string(dummyHash)
+	}
+	ok = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil && known
+
+	c.authMtx.Lock()
+	// The cache is cleared rather than evicted from, as it only grows with
+	// wrong passwords.
+	if len(c.authCache) > 1000 {
+		c.authCache = map[[sha256.Size]byte]bool{}
+	}
+	c.authCache[key] = ok
+	c.authMtx.Unlock()
+	return ok
+}
+
+// handler wraps h with the configured headers and basic authentication.
+func (c *webConfig) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range c.HTTPConfig.Headers {
+			w.Header().Set(k, v)
+		}
+		if len(c.Users) > 0 {
+			user, password, ok := r.BasicAuth()
+			if !ok || !c.authenticate(user, password) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="bunnycdn_exporter"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serve serves HTTP on the listener, with the TLS and authentication of the
+// web configuration file, if any.
+func serve(l net.Listener, server *http.Server, webConfigPath string) error {
+	if webConfigPath == "" {
+		return server.Serve(l)
+	}
+	c, err := loadWebConfig(webConfigPath)
+	if err != nil {
+		return err
+	}
+	h := server.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	server.Handler = c.handler(h)
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil {
+		return server.Serve(l)
+	}
+	server.TLSConfig = tlsConfig
+	return server.ServeTLS(l, "", "")
+}
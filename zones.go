@@ -0,0 +1,95 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// pullZoneSource lists the pull zones of an account for the collectors
+// other than the statistics: access logs, syslog, probes, origin and
+// certificate checks. It follows the filters, labels and API settings of the
+// configuration, including reloaded ones.
+type pullZoneSource struct {
+	ctx     context.Context
+	account string
+	logsURI string
+
+	mtx       sync.RWMutex
+	fetch     func(path string) (io.ReadCloser, error)
+	fetchLogs func(path string) (io.ReadCloser, error)
+	filter    zoneFilter
+	labels    map[string]string
+}
+
+// newPullZoneSource returns the source of the pull zones of an account, whose
+// access logs are downloaded from logsURI. API calls are cancelled when ctx
+// is done.
+func newPullZoneSource(ctx context.Context, account, logsURI string, c *config) *pullZoneSource {
+	s := &pullZoneSource{ctx: ctx, account: account, logsURI: logsURI}
+	s.applyConfig(c)
+	return s
+}
+
+func (s *pullZoneSource) accountName() string {
+	return s.account
+}
+
+// applyConfig switches the source to a new configuration.
+func (s *pullZoneSource) applyConfig(c *config) {
+	api := c.BunnyCDN
+	fetch := fetchHTTPContext(s.ctx, api.APIURI, api.key(), api.SSLVerify, api.Timeout)
+	fetchLogs := fetchHTTPContext(s.ctx, s.logsURI, api.key(), api.SSLVerify, api.Timeout)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.fetch = fetch
+	s.fetchLogs = fetchLogs
+	s.filter = c.filter
+	s.labels = c.Labels.PullZone
+}
+
+// list returns the pull zones selected by the filters, named after the value
+// of their pull_zone label.
+func (s *pullZoneSource) list() ([]bunnyPullZone, error) {
+	s.mtx.RLock()
+	fetch, filter, labels := s.fetch, s.filter, s.labels
+	s.mtx.RUnlock()
+
+	pullZones, err := listPullZones(fetch)
+	if err != nil {
+		return nil, err
+	}
+	var selected []bunnyPullZone
+	for _, pz := range pullZones {
+		if !filter.match(pz.Name) {
+			continue
+		}
+		if label, ok := labels[pz.Name]; ok {
+			pz.Name = label
+		}
+		selected = append(selected, pz)
+	}
+	return selected, nil
+}
+
+// logs downloads a file from the logging API.
+func (s *pullZoneSource) logs(path string) (io.ReadCloser, error) {
+	s.mtx.RLock()
+	fetchLogs := s.fetchLogs
+	s.mtx.RUnlock()
+	return fetchLogs(path)
+}
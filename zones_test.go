@@ -0,0 +1,67 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newTestPullZoneSource(apiURI, logsURI string) *pullZoneSource {
+	return newPullZoneSource(context.Background(), "", logsURI, &config{
+		BunnyCDN: bunnyConfig{APIURI: apiURI, APIKey: "api_key", SSLVerify: true, Timeout: time.Second},
+	})
+}
+
+func TestPullZoneSource(t *testing.T) {
+	var key string
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("AccessKey")
+		w.Write([]byte(`[{"Id": 1, "Name": "prod"}, {"Id": 2, "Name": "staging"}]`))
+	}))
+	defer h.Close()
+
+	s := newTestPullZoneSource(h.URL, "")
+	pullZones, err := s.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, len(pullZones), "Pull zones without filters")
+
+	// Reloads change the API key, filters and labels.
+	c := &config{
+		BunnyCDN: bunnyConfig{APIURI: h.URL, APIKey: "new_key", SSLVerify: true, Timeout: time.Second},
+		Labels:   labelsConfig{PullZone: map[string]string{"prod": "website"}},
+	}
+	c.filter.exclude = []*regexp.Regexp{regexp.MustCompile("^staging$")}
+	s.applyConfig(c)
+	pullZones, err = s.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "new_key", key, "API key after a reload")
+	assertEqual(t, 1, len(pullZones), "Pull zones selected by the filters")
+	assertEqual(t, "website", pullZones[0].Name, "Label of the pull zone")
+
+	names := &pullZoneNames{pullZones: s.list}
+	name, ok := names.lookup(1)
+	assertEqual(t, true, ok, "Known pull zone")
+	assertEqual(t, "website", name, "Syslog label of the pull zone")
+	_, ok = names.lookup(2)
+	assertEqual(t, false, ok, "Syslog lines of a filtered pull zone")
+}